@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// LoggerInfo is the state of a registered logger as reported by the admin
+// handler.
+type LoggerInfo struct {
+	Name          string   `json:"name"`
+	Level         level    `json:"level"`
+	OriginalLevel level    `json:"original_level"`
+	Flags         []string `json:"flags"`
+	Writer        string   `json:"writer"`
+	Sinks         []string `json:"sinks,omitempty"`
+}
+
+// levelRequest is the body accepted by the admin handler. An empty Logger
+// targets every registered logger, Reset restores the original level.
+type levelRequest struct {
+	Logger string `json:"logger"`
+	Level  string `json:"level"`
+	Reset  bool   `json:"reset"`
+}
+
+var flagNames = []struct {
+	flag int
+	name string
+}{
+	{Date, "DATE"},
+	{Time, "TIME"},
+	{Labels, "LABELS"},
+	{Caller, "CALLER"},
+	{ShortCaller, "SHORT_CALLER"},
+	{Dedup, "DEDUP"},
+}
+
+/*
+AdminHandler returns an http.Handler to inspect and change logger levels at
+runtime. GET lists every registered logger. PUT and POST change or reset the
+level of one logger and its descendants, or of all of them when "logger" is
+omitted, and reply with the new list.
+
+In example:
+
+	mux.Handle("/admin/loggers", logging.AdminHandler())
+
+	curl -X PUT -d '{"logger": "api", "level": "debug"}' .../admin/loggers
+	curl -X PUT -d '{"reset": true}' .../admin/loggers
+*/
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if status, err := changeLevel(r); err != nil {
+				writeJSON(w, status, map[string]string{"error": err.Error()})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, registry.info())
+	})
+}
+
+func changeLevel(r *http.Request) (status int, err error) {
+	var req levelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)
+	}
+	if req.Reset == (req.Level != "") {
+		return http.StatusBadRequest, errors.New("exactly one of level and reset must be set")
+	}
+	if req.Logger != "" && !isPattern(req.Logger) && !registry.has(req.Logger) {
+		return http.StatusNotFound, fmt.Errorf("logger with name %s does not exists", req.Logger)
+	}
+
+	switch {
+	case req.Reset && req.Logger == "":
+		ResetLevels()
+	case req.Reset:
+		err = ResetLevel(req.Logger)
+	case req.Logger == "":
+		err = SetLevelForAll(req.Level)
+	default:
+		err = SetLevelForLogger(req.Logger, req.Level)
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (l *Logger) info() LoggerInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	info := LoggerInfo{
+		Name:          l.title,
+		Level:         l.level.load(),
+		OriginalLevel: l.originalLevel,
+		Flags:         []string{},
+		Writer:        fmt.Sprintf("%T", l.w),
+	}
+	for _, s := range l.sinks {
+		info.Sinks = append(info.Sinks, fmt.Sprintf("%T", s.w))
+	}
+	for _, f := range flagNames {
+		if l.flag&f.flag != 0 {
+			info.Flags = append(info.Flags, f.name)
+		}
+	}
+	return info
+}
+
+func (r *loggerRegistry) info() []LoggerInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]LoggerInfo, 0, len(r.loggers))
+	for _, l := range r.loggers {
+		infos = append(infos, l.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
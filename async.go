@@ -0,0 +1,241 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type overflowPolicy string
+
+const (
+	defaultQueueSize = 1024
+	maxBatchSize     = 128
+)
+
+var errAsyncWriterClosed = errors.New("async writer closed")
+
+func overflowPolicyFromString(s string) (overflowPolicy, error) {
+	p := overflowPolicy(strings.ToLower(s))
+	switch p {
+	case "":
+		return BlockOnOverflow, nil
+	case BlockOnOverflow, DropNewest, DropOldest:
+		return p, nil
+	}
+	return "", fmt.Errorf("overflow policy %s invalid", s)
+}
+
+/*
+AsyncWriter queues writes in a bounded ring buffer and writes them to the
+underlying writer from a background goroutine, in batches. When the queue is
+full, the overflow policy decides whether Write waits for room
+(BlockOnOverflow), discards the new line (DropNewest) or the oldest queued one
+(DropOldest). Dropped lines are counted.
+
+Flush waits until every queued line is written, Close flushes and stops the
+goroutine. Close does not close the underlying writer.
+
+In example:
+
+	w := logging.NewAsyncWriter(f, 4096, logging.DropOldest)
+	l := logging.New(w, "api", logging.Time, logging.INFO, "|")
+	defer w.Close()
+*/
+type AsyncWriter struct {
+	w      io.Writer
+	policy overflowPolicy
+	// closeW is set for writers built from a config that own w.
+	closeW bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   [][]byte
+	head    int
+	count   int
+	writing bool
+	closed  bool
+	done    chan struct{}
+	err     error
+
+	dropped uint64
+}
+
+// NewAsyncWriter starts writing to w in the background. A size of zero or
+// less selects the default queue size.
+func NewAsyncWriter(w io.Writer, size int, policy overflowPolicy) *AsyncWriter {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	if policy == "" {
+		policy = BlockOnOverflow
+	}
+	aw := &AsyncWriter{
+		w:      w,
+		policy: policy,
+		queue:  make([][]byte, size),
+		done:   make(chan struct{}),
+	}
+	aw.cond = sync.NewCond(&aw.mu)
+	go aw.run()
+	return aw
+}
+
+// Write queues a copy of p. It never reports errors of the underlying
+// writer, see Flush for those.
+func (aw *AsyncWriter) Write(p []byte) (n int, err error) {
+	line := append([]byte(nil), p...)
+
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	for aw.count == len(aw.queue) && aw.policy == BlockOnOverflow && !aw.closed {
+		aw.cond.Wait()
+	}
+	if aw.closed {
+		return 0, errAsyncWriterClosed
+	}
+
+	if aw.count == len(aw.queue) {
+		atomic.AddUint64(&aw.dropped, 1)
+		if aw.policy == DropNewest {
+			return len(p), nil
+		}
+		aw.queue[aw.head] = nil
+		aw.head = (aw.head + 1) % len(aw.queue)
+		aw.count--
+	}
+	aw.queue[(aw.head+aw.count)%len(aw.queue)] = line
+	aw.count++
+	aw.cond.Broadcast()
+	return len(p), nil
+}
+
+// Dropped returns the number of lines discarded because the queue was full.
+func (aw *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&aw.dropped)
+}
+
+// Flush waits until every queued line is written and flushes the underlying
+// writer if it can be flushed. It returns the first write error since the
+// previous Flush.
+func (aw *AsyncWriter) Flush() error {
+	aw.mu.Lock()
+	for aw.count > 0 || aw.writing {
+		aw.cond.Wait()
+	}
+	err := aw.err
+	aw.err = nil
+	aw.mu.Unlock()
+
+	if f, ok := aw.w.(flusher); ok {
+		if flushErr := f.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// Sync flushes aw. When aw owns the underlying writer, i.e. a file opened
+// from a config, it also commits it to storage. Writers aw doesn't own, like
+// stdout, are left to their owner.
+func (aw *AsyncWriter) Sync() error {
+	err := aw.Flush()
+	if s, ok := aw.w.(syncer); ok && aw.closeW {
+		if syncErr := s.Sync(); err == nil {
+			err = syncErr
+		}
+	}
+	return err
+}
+
+// Close flushes the queue and stops the background goroutine. Later writes
+// fail.
+func (aw *AsyncWriter) Close() error {
+	aw.mu.Lock()
+	if aw.closed {
+		aw.mu.Unlock()
+		return nil
+	}
+	aw.closed = true
+	aw.cond.Broadcast()
+	aw.mu.Unlock()
+
+	<-aw.done
+	err := aw.Flush()
+	if c, ok := aw.w.(io.Closer); ok && aw.closeW {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+	var batch []byte
+
+	for {
+		aw.mu.Lock()
+		for aw.count == 0 && !aw.closed {
+			aw.cond.Wait()
+		}
+		if aw.count == 0 {
+			aw.mu.Unlock()
+			return
+		}
+
+		batch = batch[:0]
+		for i := 0; i < maxBatchSize && aw.count > 0; i++ {
+			batch = append(batch, aw.queue[aw.head]...)
+			aw.queue[aw.head] = nil
+			aw.head = (aw.head + 1) % len(aw.queue)
+			aw.count--
+		}
+		aw.writing = true
+		aw.cond.Broadcast()
+		aw.mu.Unlock()
+
+		_, err := aw.w.Write(batch)
+
+		aw.mu.Lock()
+		if err != nil && aw.err == nil {
+			aw.err = err
+		}
+		aw.writing = false
+		aw.cond.Broadcast()
+		aw.mu.Unlock()
+	}
+}
+
+// flusher is implemented by writers that buffer data, like AsyncWriter.
+type flusher interface {
+	Flush() error
+}
+
+// flush writes the pending summary of dropped records and flushes every
+// writer of l that buffers data. It is called before exiting on fatal
+// records.
+func (l *Logger) flush() {
+	c := l.root()
+	c.flushSummary()
+	var writers []io.Writer
+	for a := c; a != nil; {
+		a.mu.RLock()
+		writers = append(writers, a.w)
+		for _, s := range a.sinks {
+			writers = append(writers, s.w)
+		}
+		next := a.writers
+		a.mu.RUnlock()
+		a = next
+	}
+
+	for _, w := range writers {
+		if f, ok := w.(flusher); ok {
+			_ = f.Flush()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+configFile is the document read by LoadConfigFile. It can be written in YAML
+or JSON, keys are the yaml tags of Config. Every logger starts from defaults
+and overrides the keys it sets.
+
+In example:
+
+	defaults:
+	  separator: "|"
+	  enable_time: true
+	loggers:
+	  - title: api
+	    level: debug
+	  - title: db
+	    direction: /var/log/db.log
+	    max_size_mb: 100
+*/
+type configFile struct {
+	Defaults Config      `yaml:"defaults"`
+	Loggers  []yaml.Node `yaml:"loggers"`
+}
+
+// LoadConfigFile registers every logger described in the file at path. Valid
+// entries are registered even if others are not; the problems of the invalid
+// ones are returned together.
+func LoadConfigFile(path string) error {
+	configs, err := readConfigFile(path)
+	errs := configErrors(err)
+	for _, cfg := range configs {
+		if _, err := AddLoggerFromConfig(cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// readConfigFile returns the logger configs found in the file at path with
+// the defaults applied. Entries that can not be decoded are reported in the
+// error and left out.
+func readConfigFile(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file configFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	var (
+		configs []Config
+		errs    multiError
+	)
+	for i, node := range file.Loggers {
+		cfg := file.Defaults
+		if err := node.Decode(&cfg); err != nil {
+			errs = append(errs, fmt.Errorf("loggers[%d]: %w", i, err))
+			continue
+		}
+		if cfg.Title == "" {
+			errs = append(errs, fmt.Errorf("loggers[%d]: title is required", i))
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, errs.errorOrNil()
+}
+
+// configErrors flattens err into a multiError so more errors can be added.
+func configErrors(err error) multiError {
+	var errs multiError
+	if errors.As(err, &errs) {
+		return errs
+	}
+	if err != nil {
+		return multiError{err}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ const (
 	Labels
 	Caller
 	ShortCaller
+	Dedup
 )
 
 const (
@@ -15,6 +16,14 @@ const (
 	ERROR   level = "ERROR"
 	FATAL   level = "FATAL"
 
+	TextFormat   format = "text"
+	JSONFormat   format = "json"
+	LogfmtFormat format = "logfmt"
+
+	BlockOnOverflow overflowPolicy = "block"
+	DropNewest      overflowPolicy = "drop_newest"
+	DropOldest      overflowPolicy = "drop_oldest"
+
 	DefaultSeparator = "--"
 	sourceErr        = "UNKNOWN_SOURCE_ERROR"
 )
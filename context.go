@@ -0,0 +1,114 @@
+package logging
+
+import "context"
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	traceIDKey
+	userIDKey
+)
+
+// contextIDs are the context values added to the records of the *Ctx
+// methods, in the order they are rendered.
+var contextIDs = []struct {
+	key  contextKey
+	name string
+}{
+	{requestIDKey, "request_id"},
+	{traceIDKey, "trace_id"},
+	{userIDKey, "user_id"},
+}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the Logger carried by ctx, or a default one, with the
+// ids stored in ctx attached as fields. A nil ctx carries nothing.
+func FromContext(ctx context.Context) *Logger {
+	l, ok := contextValue(ctx, loggerKey).(*Logger)
+	if !ok {
+		l = NewDefault("")
+	}
+	if kv := contextKV(ctx); len(kv) > 0 {
+		return l.With(kv...)
+	}
+	return l
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey, id)
+}
+
+func WithUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := contextValue(ctx, requestIDKey).(string)
+	return id
+}
+
+func TraceID(ctx context.Context) string {
+	id, _ := contextValue(ctx, traceIDKey).(string)
+	return id
+}
+
+func UserID(ctx context.Context) string {
+	id, _ := contextValue(ctx, userIDKey).(string)
+	return id
+}
+
+// contextValue returns the value of key in ctx, nil when ctx is nil.
+func contextValue(ctx context.Context, key contextKey) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(key)
+}
+
+// contextKV returns the ids stored in ctx as key/value pairs.
+func contextKV(ctx context.Context) (kv []interface{}) {
+	for _, id := range contextIDs {
+		if v, ok := contextValue(ctx, id.key).(string); ok && v != "" {
+			kv = append(kv, id.name, v)
+		}
+	}
+	return kv
+}
+
+func (l *Logger) InfoCtx(ctx context.Context, msg string) {
+	l.log(INFO, msg, contextKV(ctx)...)
+}
+
+func (l *Logger) WarningCtx(ctx context.Context, msg string) {
+	l.log(WARNING, msg, contextKV(ctx)...)
+}
+
+func (l *Logger) DebugCtx(ctx context.Context, msg string) {
+	l.log(DEBUG, msg, contextKV(ctx)...)
+}
+
+func (l *Logger) ErrorCtx(ctx context.Context, msg string) {
+	l.log(ERROR, msg, contextKV(ctx)...)
+}
+
+func (l *Logger) FatalCtx(ctx context.Context, msg string) {
+	l.log(FATAL, msg, contextKV(ctx)...)
+	l.fatalExit()
+}
+
+func (l *Logger) LogErrorCtx(ctx context.Context, err error, s ...string) {
+	if err == nil {
+		return
+	}
+	l.log(ERROR, l.root().getMsgFromError(err, s), append(contextKV(ctx), errorKV(err)...)...)
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDedupTimeout = 10 * time.Second
+
+// deduper collapses records that repeat back-to-back when the Dedup flag is
+// set. The first record is written, its repetitions are counted and reported
+// by a single "last message repeated N times" record once a different record
+// arrives or the timeout passes.
+type deduper struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	key     string
+	last    *record
+	count   int
+	timer   *time.Timer
+}
+
+// SetDedupTimeout sets how long repetitions of a record are collapsed
+// before they are reported. A zero d selects the default of 10 seconds.
+func (l *Logger) SetDedupTimeout(d time.Duration) *Logger {
+	c := l.root()
+	c.dedup.mu.Lock()
+	defer c.dedup.mu.Unlock()
+	c.dedup.timeout = d
+	return l
+}
+
+// allow reports whether r may be written. It writes the summary of the
+// previous record first when r is different from it.
+func (d *deduper) allow(l *Logger, r *record) bool {
+	key := dedupKey(r)
+
+	d.mu.Lock()
+	if d.last != nil && key == d.key {
+		d.count++
+		if d.timer == nil {
+			last := d.last
+			d.timer = time.AfterFunc(d.getTimeout(), func() {
+				d.flushIfLast(l, last)
+			})
+		}
+		d.mu.Unlock()
+		return false
+	}
+	summary := d.take()
+	d.key, d.last = key, r
+	d.mu.Unlock()
+
+	if summary != nil {
+		l.write(summary)
+	}
+	return true
+}
+
+func (d *deduper) getTimeout() time.Duration {
+	if d.timeout <= 0 {
+		return defaultDedupTimeout
+	}
+	return d.timeout
+}
+
+// take returns the summary of the repetitions of the last record, or nil,
+// and forgets the last record. d.mu must be held.
+func (d *deduper) take() *record {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	last, n := d.last, d.count
+	d.key, d.last, d.count = "", nil, 0
+	if n == 0 {
+		return nil
+	}
+	return &record{
+		time:     now(),
+		level:    last.level,
+		msg:      fmt.Sprintf("last message repeated %d times", n),
+		callInfo: last.callInfo,
+		fields:   []field{{key: "repeated", value: n}},
+	}
+}
+
+// flushIfLast writes the summary of last unless another record came after
+// it in the meantime.
+func (d *deduper) flushIfLast(l *Logger, last *record) {
+	d.mu.Lock()
+	if d.last != last {
+		d.mu.Unlock()
+		return
+	}
+	summary := d.take()
+	d.mu.Unlock()
+
+	if summary != nil {
+		l.write(summary)
+	}
+}
+
+// flush writes the pending summary right away.
+func (d *deduper) flush(l *Logger) {
+	d.mu.Lock()
+	summary := d.take()
+	d.mu.Unlock()
+
+	if summary != nil {
+		l.write(summary)
+	}
+}
+
+// dedupKey identifies a record by level, caller, message and fields.
+func dedupKey(r *record) string {
+	var b strings.Builder
+	b.WriteString(string(r.level))
+	b.WriteByte(0)
+	b.WriteString(r.callInfo.file)
+	b.WriteByte(':')
+	b.WriteString(strconv.Itoa(r.callInfo.line))
+	b.WriteByte(0)
+	b.WriteString(r.msg)
+	for _, f := range r.fields {
+		b.WriteByte(0)
+		fmt.Fprintf(&b, "%s=%v", f.key, f.value)
+	}
+	return b.String()
+}
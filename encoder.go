@@ -0,0 +1,240 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type format string
+
+type record struct {
+	time     time.Time
+	level    level
+	msg      string
+	callInfo callInfo
+	fields   []field
+}
+
+// encoder turns a record into a single output line. It reads the Logger
+// configuration (flags, title, separator) but never writes it.
+type encoder interface {
+	encode(l *Logger, r *record) []byte
+}
+
+var encoders = map[format]encoder{
+	TextFormat:   textEncoder{},
+	JSONFormat:   jsonEncoder{},
+	LogfmtFormat: logfmtEncoder{},
+}
+
+func encoderFromFormat(f format) (encoder, error) {
+	if f == "" {
+		return textEncoder{}, nil
+	}
+	if enc, ok := encoders[format(strings.ToLower(string(f)))]; ok {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("format %s invalid", f)
+}
+
+func (l *Logger) getEncoder() encoder {
+	if l.enc == nil {
+		return textEncoder{}
+	}
+	return l.enc
+}
+
+// recordMsg returns the message as it should be rendered, without a
+// trailing newline.
+func recordMsg(r *record) string {
+	if len(r.msg) == 0 {
+		return "Unknown error"
+	}
+	return strings.TrimSuffix(r.msg, "\n")
+}
+
+type textEncoder struct{}
+
+func (textEncoder) encode(l *Logger, r *record) []byte {
+	data := l.getPrefix(r)
+	if l.flag&Labels != 0 {
+		data = append(data, "MSG = "...)
+	}
+
+	if len(r.msg) == 0 {
+		data = append(data, "Unknown error\n"...)
+	} else {
+		data = append(data, r.msg...)
+		if r.msg[len(r.msg)-1] != '\n' {
+			data = append(data, '\n')
+		}
+	}
+	return data
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) encode(l *Logger, r *record) []byte {
+	data := []byte{'{'}
+	add := func(key string, value interface{}) {
+		if len(data) > 1 {
+			data = append(data, ',')
+		}
+		data = append(data, jsonValue(key)...)
+		data = append(data, ':')
+		data = append(data, jsonValue(value)...)
+	}
+
+	if t := recordTime(l.flag, r.time); t != "" {
+		add("time", t)
+	}
+	add("level", r.level)
+	if l.title != "" {
+		add("title", l.title)
+	}
+	if l.flag&(Caller|ShortCaller) != 0 {
+		add("caller", callerString(l.flag, r.callInfo))
+	}
+	add("msg", recordMsg(r))
+	for _, f := range r.fields {
+		add(fieldKey(f.key), f.value)
+	}
+	return append(data, '}', '\n')
+}
+
+// reservedKeys are the keys the structured encoders write for every record.
+var reservedKeys = map[string]bool{"time": true, "level": true, "title": true, "caller": true, "msg": true}
+
+// fieldKey renames the keys of fields that collide with reserved keys to
+// "fields.<key>", so that they can't hide the level or the message.
+func fieldKey(key string) string {
+	if reservedKeys[key] {
+		return "fields." + key
+	}
+	return key
+}
+
+// recordTime formats t for the structured encoders according to the Date and
+// Time flags. An empty string means the time is omitted.
+func recordTime(flag int, t time.Time) string {
+	switch {
+	case flag&Date != 0 && flag&Time != 0:
+		return t.Format(time.RFC3339)
+	case flag&Date != 0:
+		return t.Format("2006-01-02")
+	case flag&Time != 0:
+		return t.Format("15:04:05")
+	}
+	return ""
+}
+
+func callerString(flag int, ci callInfo) string {
+	file := ci.file
+	if flag&ShortCaller != 0 {
+		file = filepath.Base(file)
+	}
+	return file + ":" + strconv.Itoa(ci.line)
+}
+
+func jsonValue(value interface{}) []byte {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	return data
+}
+
+type logfmtEncoder struct{}
+
+func (logfmtEncoder) encode(l *Logger, r *record) []byte {
+	var data []byte
+	add := func(key string, value string) {
+		if len(data) > 0 {
+			data = append(data, ' ')
+		}
+		data = append(data, logfmtKey(key)...)
+		data = append(data, '=')
+		data = append(data, logfmtValue(value)...)
+	}
+
+	if t := recordTime(l.flag, r.time); t != "" {
+		add("time", t)
+	}
+	add("level", string(r.level))
+	if l.title != "" {
+		add("title", l.title)
+	}
+	if l.flag&(Caller|ShortCaller) != 0 {
+		add("caller", callerString(l.flag, r.callInfo))
+	}
+	add("msg", recordMsg(r))
+	for _, f := range r.fields {
+		if err, ok := f.value.(error); ok {
+			add(fieldKey(f.key), err.Error())
+		} else {
+			add(fieldKey(f.key), fmt.Sprint(f.value))
+		}
+	}
+	return append(data, '\n')
+}
+
+// logfmtKey replaces every character that would break key parsing.
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes the value when it is empty or contains spaces, quotes,
+// equal signs or control characters.
+func logfmtValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " =\"\\") && !containsControl(value) {
+		return value
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < ' ' || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func containsControl(s string) bool {
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,69 @@
 package logging
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 	"strings"
+	"sync"
 )
 
+const (
+	defaultTraceDepth = 32
+	maxTraceDepth     = 256
+)
+
+/*
+TraceOptions control what Trace records. By default it records the frame it
+is called from. With FullStack it records the whole stack of the goroutine,
+up to MaxDepth frames (32 when zero) of those kept by Filter, which keeps
+every frame when nil.
+
+In example:
+
+	logging.SetTraceOptions(logging.TraceOptions{
+		FullStack: true,
+		MaxDepth:  16,
+		Filter:    logging.SkipFrames("runtime.", "testing."),
+	})
+*/
+type TraceOptions struct {
+	FullStack bool
+	MaxDepth  int
+	Filter    func(frame runtime.Frame) bool
+}
+
+var (
+	traceOptionsMu sync.RWMutex
+	traceOptions   TraceOptions
+)
+
+// SetTraceOptions changes what later calls to Trace record.
+func SetTraceOptions(opts TraceOptions) {
+	traceOptionsMu.Lock()
+	defer traceOptionsMu.Unlock()
+	traceOptions = opts
+}
+
+func getTraceOptions() TraceOptions {
+	traceOptionsMu.RLock()
+	defer traceOptionsMu.RUnlock()
+	return traceOptions
+}
+
+// SkipFrames returns a TraceOptions filter that drops the frames of functions
+// whose name starts with one of prefixes, like "runtime.".
+func SkipFrames(prefixes ...string) func(frame runtime.Frame) bool {
+	return func(frame runtime.Frame) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(frame.Function, prefix) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 type TraceableError interface {
 	GetTrace() string
 	GetAllStackFrames() []string
@@ -14,13 +72,23 @@ type TraceableError interface {
 type traceableError struct {
 	err   error
 	frame string
+	// msg and fields are the context added with TraceMsg and TraceWith.
+	msg    string
+	fields []field
+	// stack holds the frames of the whole stack when Trace was called with
+	// TraceOptions.FullStack, in which case frame is unused.
+	stack []string
 }
 
 func (trErr *traceableError) Error() string {
+	msg := "unknown (unspecified) error"
 	if trErr.err != nil {
-		return trErr.err.Error()
+		msg = trErr.err.Error()
 	}
-	return "unknown (unspecified) error"
+	if trErr.msg != "" {
+		return trErr.msg + ": " + msg
+	}
+	return msg
 }
 
 func (trErr *traceableError) Unwrap() error {
@@ -31,23 +99,206 @@ func (trErr *traceableError) GetTrace() string {
 	return strings.Join(trErr.GetAllStackFrames(), "\n")
 }
 
-func (trErr *traceableError) GetAllStackFrames() (frames []string) {
-	frames = append(frames, trErr.frame)
-	if err, ok := trErr.err.(TraceableError); ok {
-		frames = append(frames, err.GetAllStackFrames()...)
-	} else {
-		frames = append(frames, fmt.Sprintf("\terror occurred: %s", trErr.Error()))
+// GetAllStackFrames returns the frames recorded by every traced error of the
+// chain, outermost first. When an error and the one it wraps both recorded
+// full stacks, the callers they have in common are only returned for the
+// outer one. Errors that wrap several ones, like those of errors.Join, are
+// rendered as one indented branch per wrapped error.
+func (trErr *traceableError) GetAllStackFrames() []string {
+	return trErr.stackFrames(nil)
+}
+
+// stackFrames returns the frames of trErr and of the errors it wraps. outer
+// is the full stack of the traced error that wraps trErr, if any.
+func (trErr *traceableError) stackFrames(outer []string) []string {
+	own := []string{trErr.frame}
+	if trErr.stack != nil {
+		own = withoutCommonTail(trErr.stack, outer)
+	}
+	if context := trErr.context(); context != "" && len(own) > 0 {
+		own[0] += "\n\t\t" + context
+	}
+	return append(own, errorFrames(trErr.err, trErr.stack)...)
+}
+
+// errorFrames returns the frames of err, which is wrapped by a traced error
+// with the full stack outer, if any.
+func errorFrames(err error, outer []string) []string {
+	switch e := err.(type) {
+	case nil:
+		return []string{"\terror occurred: unknown (unspecified) error"}
+	case *traceableError:
+		return e.stackFrames(outer)
+	case TraceableError:
+		return e.GetAllStackFrames()
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		frames := []string{fmt.Sprintf("\t%d errors occurred:", len(errs))}
+		for i, branch := range errs {
+			frames = append(frames, fmt.Sprintf("\tbranch %d of %d:", i+1, len(errs)))
+			for _, frame := range errorFrames(branch, outer) {
+				frames = append(frames, "\t"+strings.ReplaceAll(frame, "\n", "\n\t"))
+			}
+		}
+		return frames
+	}
+	return []string{fmt.Sprintf("\terror occurred: %s", err)}
+}
+
+// withoutCommonTail returns a copy of stack without the callers it shares
+// with outer, i.e. the longest common suffix. The first frame, where Trace
+// was called, is always kept.
+func withoutCommonTail(stack, outer []string) []string {
+	n := len(stack)
+	for m := len(outer); n > 1 && m > 0 && stack[n-1] == outer[m-1]; m-- {
+		n--
+	}
+	return append([]string(nil), stack[:n]...)
+}
+
+// context renders the message and fields of trErr as "msg key=value ...".
+func (trErr *traceableError) context() string {
+	parts := make([]string, 0, len(trErr.fields)+1)
+	if trErr.msg != "" {
+		parts = append(parts, trErr.msg)
+	}
+	for _, f := range trErr.fields {
+		parts = append(parts, fmt.Sprintf("%s=%v", f.key, f.value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// errorKV returns the fields added with TraceWith to the traced errors of the
+// chain of err, outermost first, as key/value pairs. When the chain reaches
+// an error that wraps several ones, their tree is added as "errors".
+func errorKV(err error) (kv []interface{}) {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if trErr, ok := e.(*traceableError); ok {
+			for _, f := range trErr.fields {
+				kv = append(kv, f.key, f.value)
+			}
+		}
+		if _, ok := e.(interface{ Unwrap() []error }); ok {
+			kv = append(kv, "errors", newErrorTree(e))
+		}
+	}
+	return kv
+}
+
+// errorTree is the tree of the errors wrapped by an error like those of
+// errors.Join. Every node is either a message, the list of the subtrees of
+// an error that wraps several ones, or an errorBranch when that error is
+// wrapped behind a message. It is rendered as nested lists, i.e.
+// "[a; ctx: [b; c]]" in text and nested arrays in JSON.
+type errorTree []interface{}
+
+// errorBranch is an error that wraps several ones behind its own message,
+// like TraceMsg(errors.Join(b, c), "ctx"). It is rendered as "ctx: [b; c]"
+// in text and {"msg":"ctx","errors":["b","c"]} in JSON.
+type errorBranch struct {
+	Msg    string    `json:"msg"`
+	Errors errorTree `json:"errors"`
+}
+
+func (b errorBranch) String() string {
+	return b.Msg + ": " + b.Errors.String()
+}
+
+func newErrorTree(err error) errorTree {
+	var tree errorTree
+	for _, branch := range err.(interface{ Unwrap() []error }).Unwrap() {
+		tree = append(tree, errorTreeNode(branch))
 	}
+	return tree
+}
 
-	return
+func errorTreeNode(err error) interface{} {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if _, ok := e.(interface{ Unwrap() []error }); ok {
+			tree := newErrorTree(e)
+			if e == err {
+				return tree
+			}
+			// The message err adds in front of the ones of e.
+			msg := strings.TrimSuffix(strings.TrimSuffix(err.Error(), e.Error()), ": ")
+			if msg == "" {
+				return tree
+			}
+			return errorBranch{Msg: msg, Errors: tree}
+		}
+	}
+	if err == nil {
+		return "unknown (unspecified) error"
+	}
+	return err.Error()
+}
+
+func (tree errorTree) String() string {
+	parts := make([]string, len(tree))
+	for i, node := range tree {
+		parts[i] = fmt.Sprint(node)
+	}
+	return "[" + strings.Join(parts, "; ") + "]"
 }
 
 func (trErr *traceableError) getCurrentStackFrame() string {
 	if info := getCallInfo(); info.pc != 0 {
 		if fn := runtime.FuncForPC(info.pc); fn != nil {
-			return fmt.Sprintf("\t%s\n\t\t%s:%d", fn.Name(), info.file, info.line)
+			return formatFrame(fn.Name(), info.file, info.line)
 		}
 		return fmt.Sprintf("\t%s:%d", info.file, info.line)
 	}
 	return "unknown stack frame"
 }
+
+// captureStack returns the frames of the stack that are kept by opts,
+// skipping skip frames above its caller.
+func (trErr *traceableError) captureStack(skip int, opts TraceOptions) []string {
+	depth := opts.MaxDepth
+	if depth <= 0 {
+		depth = defaultTraceDepth
+	}
+
+	// Skip runtime.Callers and captureStack too.
+	pcs := make([]uintptr, maxTraceDepth)
+	pcs = pcs[:runtime.Callers(skip+2, pcs)]
+	frames := runtime.CallersFrames(pcs)
+
+	stack := []string{}
+	for len(stack) < depth {
+		frame, more := frames.Next()
+		if frame.PC != 0 && (opts.Filter == nil || opts.Filter(frame)) {
+			stack = append(stack, formatFrame(frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+func formatFrame(function, file string, line int) string {
+	return fmt.Sprintf("\t%s\n\t\t%s:%d", function, file, line)
+}
+
+// multiError reports several independent errors as one.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (m multiError) Unwrap() []error {
+	return m
+}
+
+func (m multiError) errorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
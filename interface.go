@@ -1,6 +1,8 @@
 package logging
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -18,6 +20,34 @@ func GetLogger(name string) (*Logger, error) {
 	return registry.getLogger(name)
 }
 
+/*
+GetOrCreateLogger returns the logger named name like GetLogger. When there is
+none but an ancestor is registered, e.g. "billing" for "billing.invoice.pdf",
+a logger is created, registered and returned. It has the flags, separator,
+format and level of the nearest ancestor and writes to the writer and sinks
+that ancestor has at the time, until it is given a writer of its own.
+
+In example:
+
+	_, _ = logging.AddLoggerFromConfig(logging.Config{Title: "billing"})
+	pdf, _ := logging.GetOrCreateLogger("billing.invoice.pdf")
+*/
+func GetOrCreateLogger(name string) (*Logger, error) {
+	return registry.getOrCreateLogger(name)
+}
+
+/*
+SetLevelForLogger sets the level of the logger named name and of its
+descendants, except those below a name whose level is set too. The name may
+also be a pattern in the syntax of path.Match, which sets the level of every
+matching logger. Levels set by name or pattern also apply to loggers
+registered later, until they are reset.
+
+In example:
+
+	_ = logging.SetLevelForLogger("billing", "debug")
+	_ = logging.SetLevelForLogger("*.http", "warning")
+*/
 func SetLevelForLogger(name string, level string) error {
 	lvl, err := levelFromString(level)
 	if err != nil {
@@ -26,6 +56,8 @@ func SetLevelForLogger(name string, level string) error {
 	return registry.setLevelForLogger(name, lvl)
 }
 
+// SetLevelForAll sets the level of every registered logger and removes the
+// levels set by name or pattern.
 func SetLevelForAll(level string) error {
 	lvl, err := levelFromString(level)
 	if err != nil {
@@ -35,27 +67,34 @@ func SetLevelForAll(level string) error {
 	return nil
 }
 
+// ResetLevels removes every level set by name or pattern and restores the
+// original level of every logger.
 func ResetLevels() {
 	registry.resetLevels()
 }
 
+// ResetLevel removes the level set for loggerName, which may be a pattern.
+// The loggers it matched go back to the level they inherit otherwise, or to
+// their original level.
 func ResetLevel(loggerName string) error {
-	l, err := registry.getLogger(loggerName)
-	if err != nil {
-		return err
-	}
-	l.resetLevel()
-	return nil
+	return registry.resetLevel(loggerName)
+}
+
+// LevelRules lists the levels set by name or pattern with SetLevelForLogger,
+// in the order they were set, with the loggers each one matches.
+func LevelRules() []LevelRule {
+	return registry.rules()
 }
 func New(w io.Writer, title string, flag int, level level, separator string) *Logger {
-	return &Logger{
+	l := &Logger{
 		w:             w,
 		title:         title,
 		flag:          flag,
-		level:         level,
 		originalLevel: level,
 		separator:     separator,
 	}
+	l.level.store(level)
+	return l
 }
 
 func NewDefault(title string, l ...level) *Logger {
@@ -80,86 +119,324 @@ type Config struct {
 	EnableLabels      bool   `yaml:"enable_labels"`
 	EnableCaller      bool   `yaml:"enable_caller"`
 	EnableShortCaller bool   `yaml:"enable_short_caller"`
+	EnableDedup       bool   `yaml:"enable_dedup"`
+	Format            format `yaml:"format"`
+
+	// Rotation of file directions, see RotatingFile. All zero means the file
+	// is never rotated.
+	MaxSizeMB  int  `yaml:"max_size_mb"`
+	MaxBackups int  `yaml:"max_backups"`
+	MaxAgeDays int  `yaml:"max_age_days"`
+	Compress   bool `yaml:"compress"`
+
+	// Async makes every writer of the logger an AsyncWriter with the given
+	// queue size and overflow policy.
+	Async          bool           `yaml:"async"`
+	AsyncQueueSize int            `yaml:"async_queue_size"`
+	AsyncOverflow  overflowPolicy `yaml:"async_overflow"`
+
+	// Sampling and RateLimit drop records of hot call sites, see
+	// Logger.SetSampling and Logger.SetRateLimit.
+	Sampling  SamplingConfig  `yaml:"sampling"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// Sinks are written to in addition to Direction. When there are sinks
+	// and Direction is empty, the logger writes to the sinks only.
+	Sinks []SinkConfig `yaml:"sinks"`
 }
 
+func (cfg Config) rotates() bool {
+	return cfg.MaxSizeMB > 0 || cfg.MaxBackups > 0 || cfg.MaxAgeDays > 0 || cfg.Compress
+}
+
+// NewFromConfig builds a Logger from cfg, ignoring invalid values. Use
+// NewFromConfigE to have them reported instead.
 func NewFromConfig(cfg Config) *Logger {
+	l, _ := newFromConfig(cfg)
+	return l
+}
+
+// NewFromConfigE builds a Logger from cfg and reports every problem found in
+// it at once: an unknown level or format, a direction that can not be opened
+// or conflicting options.
+func NewFromConfigE(cfg Config) (*Logger, error) {
+	l, err := newFromConfig(cfg)
+	if err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("invalid config for logger %s: %w", cfg.Title, err)
+	}
+	return l, nil
+}
+
+func newFromConfig(cfg Config) (*Logger, error) {
+	errs := cfg.validate()
 	l := new(Logger)
 	l.title = cfg.Title
+	l.cfg = &cfg
 
-	if cfg.Direction == "stdout" {
-		l.SetWriter(os.Stdout)
-	} else if cfg.Direction == "stderr" {
-		l.SetWriter(os.Stderr)
-	} else if cfg.Direction == "" {
-		l.SetWriter(os.Stdout)
-	} else {
-		f, _ := os.OpenFile(cfg.Direction, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		l.SetWriter(f)
+	if cfg.hasWriter() {
+		w, owned, err := cfg.openWriter(cfg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		l.SetWriter(w)
+		l.ownsW = owned
 	}
-	l.SetLevel(WARNING)
-	l.originalLevel = WARNING
-	lvl := level(strings.ToUpper(string(cfg.Level)))
-	if lvl != "" {
-		l.SetLevel(lvl)
-		l.originalLevel = lvl
+	sinks, sinkErrs := openSinks(cfg)
+	errs = append(errs, sinkErrs...)
+	l.sinks = sinks
+
+	l.SetLevel(cfg.level())
+	l.originalLevel = cfg.level()
+	l.SetSeparator(cfg.separator())
+	if enc, err := encoderFromFormat(cfg.Format); err == nil {
+		l.enc = enc
 	}
+	l.SetFlags(cfg.flags())
+	l.limiter = newLimiter(cfg.Sampling, cfg.RateLimit)
 
+	return l, errs.errorOrNil()
+}
+
+func (cfg Config) validate() (errs multiError) {
+	if cfg.Level != "" {
+		if _, err := levelFromString(string(cfg.Level)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := encoderFromFormat(cfg.Format); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.EnableCaller && cfg.EnableShortCaller {
+		errs = append(errs, errors.New("enable_caller and enable_short_caller are mutually exclusive"))
+	}
+	if cfg.MaxSizeMB < 0 || cfg.MaxBackups < 0 || cfg.MaxAgeDays < 0 {
+		errs = append(errs, errors.New("max_size_mb, max_backups and max_age_days must not be negative"))
+	}
+	if _, err := overflowPolicyFromString(string(cfg.AsyncOverflow)); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.AsyncQueueSize < 0 {
+		errs = append(errs, errors.New("async_queue_size must not be negative"))
+	}
+	errs = append(errs, cfg.Sampling.validate()...)
+	errs = append(errs, cfg.RateLimit.validate()...)
+	for i, sc := range cfg.Sinks {
+		for _, err := range sc.config().validate() {
+			errs = append(errs, fmt.Errorf("sinks[%d]: %w", i, err))
+		}
+	}
+	return errs
+}
+
+func (cfg Config) level() level {
+	if cfg.Level == "" {
+		return WARNING
+	}
+	return level(strings.ToUpper(string(cfg.Level)))
+}
+
+func (cfg Config) separator() string {
 	if cfg.Separator == "" {
-		l.SetSeparator("--")
-	} else {
-		l.SetSeparator(cfg.Separator)
+		return DefaultSeparator
 	}
+	return cfg.Separator
+}
 
+func (cfg Config) flags() (flag int) {
 	if cfg.EnableDate {
-		l.SetFlags(Date)
+		flag |= Date
 	}
 	if cfg.EnableTime {
-		l.SetFlags(Time)
+		flag |= Time
 	}
 	if cfg.EnableCaller {
-		l.SetFlags(Caller)
+		flag |= Caller
 	}
 	if cfg.EnableShortCaller {
-		l.SetFlags(ShortCaller)
+		flag |= ShortCaller
 	}
 	if cfg.EnableLabels {
-		l.SetFlags(Labels)
+		flag |= Labels
 	}
+	if cfg.EnableDedup {
+		flag |= Dedup
+	}
+	return flag
+}
 
-	return l
+func (cfg Config) format() format {
+	if cfg.Format == "" {
+		return TextFormat
+	}
+	return format(strings.ToLower(string(cfg.Format)))
+}
+
+// sameWriter reports whether both configs write to the same destination.
+func (cfg Config) sameWriter(other Config) bool {
+	return cfg.hasWriter() == other.hasWriter() &&
+		cfg.Direction == other.Direction &&
+		cfg.MaxSizeMB == other.MaxSizeMB &&
+		cfg.MaxBackups == other.MaxBackups &&
+		cfg.MaxAgeDays == other.MaxAgeDays &&
+		cfg.Compress == other.Compress &&
+		cfg.sameAsync(other)
+}
+
+func (cfg Config) sameAsync(other Config) bool {
+	return cfg.Async == other.Async &&
+		cfg.AsyncQueueSize == other.AsyncQueueSize &&
+		cfg.AsyncOverflow == other.AsyncOverflow
+}
+
+// hasWriter reports whether a logger built from cfg has a writer besides
+// its sinks.
+func (cfg Config) hasWriter() bool {
+	return cfg.Direction != "" || len(cfg.Sinks) == 0
+}
+
+// sameSinks reports whether both configs have the same sinks.
+func (cfg Config) sameSinks(other Config) bool {
+	if len(cfg.Sinks) != len(other.Sinks) {
+		return false
+	}
+	for i := range cfg.Sinks {
+		if cfg.Sinks[i] != other.Sinks[i] {
+			return false
+		}
+	}
+	return cfg.sameAsync(other)
+}
+
+// ownsWriter reports whether the writer built for cfg was opened by this
+// package and must be closed by it.
+func (cfg Config) ownsWriter() bool {
+	switch cfg.Direction {
+	case "", "stdout", "stderr":
+		return false
+	}
+	return true
+}
+
+// openWriter opens the direction of dir and wraps it in an AsyncWriter when
+// cfg asks for it. owned reports whether the writer must be closed by the
+// logger.
+func (cfg Config) openWriter(dir Config) (w io.Writer, owned bool, err error) {
+	if w, err = openDirection(dir); err != nil {
+		return w, false, err
+	}
+	owned = dir.ownsWriter()
+	if cfg.Async {
+		policy, _ := overflowPolicyFromString(string(cfg.AsyncOverflow))
+		aw := NewAsyncWriter(w, cfg.AsyncQueueSize, policy)
+		aw.closeW = owned
+		return aw, true, nil
+	}
+	return w, owned, nil
+}
+
+// openDirection returns the writer for cfg.Direction. On failure the
+// returned writer is the nil file, to keep NewFromConfig lenient.
+func openDirection(cfg Config) (io.Writer, error) {
+	switch cfg.Direction {
+	case "stdout", "":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+
+	if cfg.rotates() {
+		f, err := NewRotatingFile(cfg.Direction, cfg.MaxSizeMB, cfg.MaxBackups, cfg.MaxAgeDays, cfg.Compress)
+		if err != nil {
+			return (*os.File)(nil), err
+		}
+		return f, nil
+	}
+	f, err := os.OpenFile(cfg.Direction, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	return f, err
 }
 
+// SetWriter makes l write to w. Loggers created by GetOrCreateLogger stop
+// writing to the writers of their ancestor.
 func (l *Logger) SetWriter(w io.Writer) *Logger {
-	l.w = w
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w = w
+	c.writers = nil
 	return l
 }
 
+// GetLevelInt returns 0 to 4 for the levels DEBUG to FATAL. A custom level
+// gets the value of the most severe predefined level at or below it, or -1
+// when it is below DEBUG. See GetSeverity for the exact order.
 func (l *Logger) GetLevelInt() int {
-	return sortedLevels[l.level]
+	return l.root().level.load().levelInt()
+}
+
+// GetSeverity returns the severity of the level of l, see RegisterLevel.
+func (l *Logger) GetSeverity() int {
+	return l.root().level.load().severity()
 }
 
 func (l *Logger) SetFlags(flag int) *Logger {
-	l.flag = l.flag | flag
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flag = c.flag | flag
 	return l
 }
 
 func (l *Logger) UnsetFlags(flag int) *Logger {
-	l.flag = l.flag &^ flag
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flag = c.flag &^ flag
 	return l
 }
 
 func (l *Logger) SetSeparator(separator string) *Logger {
-	l.separator = separator
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.separator = separator
 	return l
 }
 
 func (l *Logger) SetLevel(level level) *Logger {
-	l.level = level
+	l.root().level.store(level)
+	return l
+}
+
+// SetFormat selects the output format of l. Unknown formats fall back to
+// TextFormat.
+func (l *Logger) SetFormat(f format) *Logger {
+	enc, err := encoderFromFormat(f)
+	if err != nil {
+		enc = textEncoder{}
+	}
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enc = enc
 	return l
 }
 
 func (l *Logger) GetWriter() io.Writer {
-	return l.w
+	c := l.root()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.w
+}
+
+// With returns a child Logger that adds the given key/value pairs to every
+// record it writes. The child shares the configuration of l, so level, flag,
+// writer and separator changes made through either of them apply to both.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	return &Logger{
+		parent: l.root(),
+		fields: mergeFields(l.fields, fieldsFromKV(kv)),
+	}
 }
 
 func (l *Logger) Info(msg string) {
@@ -178,32 +455,156 @@ func (l *Logger) Error(msg string) {
 	l.log(ERROR, msg)
 }
 
+// Log writes msg at lvl, which may be a level added with RegisterLevel. A
+// FATAL lvl exits like Fatal.
+func (l *Logger) Log(lvl level, msg string) {
+	l.log(lvl, msg)
+	if lvl == FATAL {
+		l.fatalExit()
+	}
+}
+
 func (l *Logger) Fatal(msg string) {
 	l.log(FATAL, msg)
-	exit(1)
+	l.fatalExit()
+}
+
+func (l *Logger) InfoKV(msg string, kv ...interface{}) {
+	l.log(INFO, msg, kv...)
+}
+
+func (l *Logger) WarningKV(msg string, kv ...interface{}) {
+	l.log(WARNING, msg, kv...)
+}
+
+func (l *Logger) DebugKV(msg string, kv ...interface{}) {
+	l.log(DEBUG, msg, kv...)
+}
+
+func (l *Logger) ErrorKV(msg string, kv ...interface{}) {
+	l.log(ERROR, msg, kv...)
+}
+
+func (l *Logger) FatalKV(msg string, kv ...interface{}) {
+	l.log(FATAL, msg, kv...)
+	l.fatalExit()
 }
 
 func (l *Logger) LogError(err error, s ...string) {
 	if err == nil {
 		return
 	}
-	l.log(ERROR, l.getMsgFromError(err, s))
+	l.log(ERROR, l.root().getMsgFromError(err, s), errorKV(err)...)
 }
 
 func (l *Logger) LogFatal(err error, s ...string) {
 	if err == nil {
 		return
 	}
-	l.log(FATAL, l.getMsgFromError(err, s))
-	exit(1)
+	l.log(FATAL, l.root().getMsgFromError(err, s), errorKV(err)...)
+	l.fatalExit()
+}
+
+// The formatting variants below only call fmt.Sprintf once the level check
+// has passed. They call log directly, as every logging method must, to keep
+// the caller reported by getCallInfo correct.
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.enabled(INFO) {
+		l.log(INFO, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	if l.enabled(WARNING) {
+		l.log(WARNING, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.enabled(DEBUG) {
+		l.log(DEBUG, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l.enabled(ERROR) {
+		l.log(ERROR, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	if l.enabled(FATAL) {
+		l.log(FATAL, fmt.Sprintf(format, args...))
+	}
+	l.fatalExit()
+}
+
+func (l *Logger) LogErrorf(err error, format string, args ...interface{}) {
+	if err == nil || !l.enabled(ERROR) {
+		return
+	}
+	l.log(ERROR, l.root().getMsgFromError(err, []string{fmt.Sprintf(format, args...)}), errorKV(err)...)
+}
+
+func (l *Logger) LogFatalf(err error, format string, args ...interface{}) {
+	if err == nil {
+		return
+	}
+	if l.enabled(FATAL) {
+		l.log(FATAL, l.root().getMsgFromError(err, []string{fmt.Sprintf(format, args...)}), errorKV(err)...)
+	}
+	l.fatalExit()
 }
 
+// Trace wraps err in a TraceableError that records where Trace was called,
+// see TraceOptions.
 func Trace(err error) error {
 	if err == nil {
 		return nil
 	}
 	trErr := new(traceableError)
 	trErr.err = err
-	trErr.frame = trErr.getCurrentStackFrame()
+	if opts := getTraceOptions(); opts.FullStack {
+		trErr.stack = trErr.captureStack(1, opts)
+	} else {
+		trErr.frame = trErr.getCurrentStackFrame()
+	}
+	return trErr
+}
+
+// TraceMsg is Trace with a message that prefixes the one of err, like
+// fmt.Errorf("loading invoice: %w", err) would, and is shown in the trace.
+func TraceMsg(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	trErr := &traceableError{err: err, msg: msg}
+	if opts := getTraceOptions(); opts.FullStack {
+		trErr.stack = trErr.captureStack(1, opts)
+	} else {
+		trErr.frame = trErr.getCurrentStackFrame()
+	}
+	return trErr
+}
+
+/*
+TraceWith is Trace with key/value pairs that are shown in the trace and
+logged as fields by LogError and the like.
+
+In example:
+
+	return logging.TraceWith(err, "invoice", id, "attempt", n)
+*/
+func TraceWith(err error, kv ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	trErr := &traceableError{err: err, fields: fieldsFromKV(kv)}
+	if opts := getTraceOptions(); opts.FullStack {
+		trErr.stack = trErr.captureStack(1, opts)
+	} else {
+		trErr.frame = trErr.getCurrentStackFrame()
+	}
 	return trErr
 }
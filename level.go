@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// levelTable orders the known levels by severity. It is never changed once
+// stored, RegisterLevel stores a new one.
+type levelTable struct {
+	severities map[level]int
+	// sorted holds the levels by increasing severity.
+	sorted []level
+}
+
+var (
+	levelsMu sync.Mutex
+	levels   atomic.Value
+)
+
+func init() {
+	levels.Store(newLevelTable(map[level]int{
+		DEBUG:   10,
+		INFO:    20,
+		WARNING: 30,
+		ERROR:   40,
+		FATAL:   50,
+	}))
+}
+
+func newLevelTable(severities map[level]int) *levelTable {
+	t := &levelTable{severities: severities}
+	for lvl := range severities {
+		t.sorted = append(t.sorted, lvl)
+	}
+	sort.Slice(t.sorted, func(i, j int) bool {
+		return severities[t.sorted[i]] < severities[t.sorted[j]]
+	})
+	return t
+}
+
+func getLevelTable() *levelTable {
+	return levels.Load().(*levelTable)
+}
+
+/*
+RegisterLevel adds a level with the given name and severity and returns it.
+The severities of DEBUG, INFO, WARNING, ERROR and FATAL are 10, 20, 30, 40
+and 50. Registering a known name again changes its severity, except for the
+predefined levels. Severities are positive and two levels can't share one.
+
+In example:
+
+	TRACE, _ := logging.RegisterLevel("trace", 5)
+	NOTICE, _ := logging.RegisterLevel("notice", 25)
+	l.Log(NOTICE, "disk usage above 80%")
+*/
+func RegisterLevel(name string, severity int) (level, error) {
+	lvl := level(strings.ToUpper(name))
+	if lvl == "" {
+		return "", errors.New("level name is required")
+	}
+	switch lvl {
+	case DEBUG, INFO, WARNING, ERROR, FATAL:
+		return "", fmt.Errorf("level %s is predefined", lvl)
+	}
+
+	if severity <= 0 {
+		return "", fmt.Errorf("severity %d of level %s must be positive", severity, lvl)
+	}
+
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	t := getLevelTable()
+	severities := make(map[level]int, len(t.severities)+1)
+	for known, s := range t.severities {
+		if s == severity && known != lvl {
+			return "", fmt.Errorf("severity %d of level %s already used by level %s", severity, lvl, known)
+		}
+		severities[known] = s
+	}
+	severities[lvl] = severity
+	levels.Store(newLevelTable(severities))
+	return lvl, nil
+}
+
+// severity returns the severity of lvl, 0 for unknown levels.
+func (lvl level) severity() int {
+	return getLevelTable().severities[lvl]
+}
+
+// levelInt returns 0 to 4 for DEBUG to FATAL. Custom levels get the value of
+// the most severe predefined level at or below them, -1 below DEBUG, and
+// unknown levels 0.
+func (lvl level) levelInt() int {
+	severity := lvl.severity()
+	switch {
+	case severity == 0:
+		return 0
+	case severity < DEBUG.severity():
+		return -1
+	case severity >= FATAL.severity():
+		return 4
+	}
+	return (severity - DEBUG.severity()) / (INFO.severity() - DEBUG.severity())
+}
+
+func levelFromString(s string) (lvl level, err error) {
+	lvl = level(strings.ToUpper(s))
+	if _, ok := getLevelTable().severities[lvl]; !ok {
+		return "", fmt.Errorf("level %s invalid", lvl)
+	}
+	return lvl, nil
+}
@@ -8,6 +8,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,7 +17,8 @@ import (
 Configurable thread-safe custom Logger with method chaining.
 
 Features:
-- 5 levels of severity such as DEBUG, INFO, WARNING, ERROR and FATAL.
+- 5 levels of severity such as DEBUG, INFO, WARNING, ERROR and FATAL, and
+custom ones added with RegisterLevel.
 - configurable log format
 - default level support to ignore all levels with lower priority
 
@@ -30,37 +33,98 @@ logger := logging.New(
 	)
 */
 
-var sortedLevels = map[level]int{
-	DEBUG:   0,
-	INFO:    1,
-	WARNING: 2,
-	ERROR:   3,
-	FATAL:   4,
-}
-
 type level string
 
-func levelFromString(s string) (lvl level, err error) {
-	lvl = level(strings.ToUpper(s))
-	if _, ok := sortedLevels[lvl]; !ok {
-		return "", fmt.Errorf("level %s invalid", lvl)
-	}
-	return lvl, nil
-}
-
 type callInfo struct {
 	file string
 	line int
 	pc   uintptr
 }
 
+type field struct {
+	key   string
+	value interface{}
+}
+
+// fieldsFromKV turns alternating key/value arguments into fields. A trailing
+// key without a value is kept with a nil value.
+func fieldsFromKV(kv []interface{}) (fields []field) {
+	for i := 0; i < len(kv); i += 2 {
+		f := field{key: fmt.Sprint(kv[i])}
+		if i+1 < len(kv) {
+			f.value = kv[i+1]
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// mergeFields returns the inherited fields followed by own ones. Own fields
+// replace inherited fields with the same key.
+func mergeFields(inherited, own []field) []field {
+	fields := make([]field, 0, len(inherited)+len(own))
+	for _, f := range inherited {
+		replaced := false
+		for _, o := range own {
+			if o.key == f.key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			fields = append(fields, f)
+		}
+	}
+	return append(fields, own...)
+}
+
+// atomicLevel holds a level that can be read and changed concurrently.
+type atomicLevel struct {
+	v atomic.Value
+}
+
+func (a *atomicLevel) load() level {
+	lvl, _ := a.v.Load().(level)
+	return lvl
+}
+
+func (a *atomicLevel) store(lvl level) {
+	a.v.Store(lvl)
+}
+
+// Logger is safe for concurrent use. The level is read atomically, the rest
+// of the configuration is guarded by mu and writes to w are serialised by
+// writeMu so that lines from different goroutines never interleave. The
+// title never changes after construction.
 type Logger struct {
 	title         string
 	separator     string
-	level         level
+	level         atomicLevel
 	originalLevel level
 	flag          int
 	w             io.Writer
+	enc           encoder
+	// ownsW is set when w was opened from a config and must be closed by l.
+	ownsW bool
+	sinks []*sink
+	// limiter is nil unless sampling or rate limiting is set up.
+	limiter *limiter
+	// dedup collapses repeated records when the Dedup flag is set.
+	dedup deduper
+	// cfg is the config l was built from, nil for loggers built in code.
+	cfg *Config
+
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+
+	// writers is set on loggers created by GetOrCreateLogger until they get
+	// a writer of their own. They write to the writers of that ancestor too.
+	writers *Logger
+
+	// parent is set on loggers created with With; they share the parent's
+	// configuration and only add their own fields.
+	parent *Logger
+	fields []field
 }
 
 var (
@@ -68,9 +132,14 @@ var (
 	caller = runtime.Caller
 )
 
+// callDepth is the number of frames between caller and the code we want to
+// report: getCallInfo itself, Logger.log (or getCurrentStackFrame) and the
+// exported method. Exported methods must therefore call log directly and
+// never through another helper.
+const callDepth = 3
+
 func getCallInfo() callInfo {
-	// skip 3 frames from stack to get right caller
-	pc, file, line, ok := caller(3)
+	pc, file, line, ok := caller(callDepth)
 	if !ok {
 		file = sourceErr
 		line = -1
@@ -78,15 +147,40 @@ func getCallInfo() callInfo {
 	return callInfo{file: file, line: line, pc: pc}
 }
 
+func (l *Logger) root() *Logger {
+	if l.parent != nil {
+		return l.parent
+	}
+	return l
+}
+
+// enabled reports whether a record of the given level would be written.
+func (l *Logger) enabled(lvl level) bool {
+	return l.root().isLevelHigherThanDefault(lvl)
+}
+
 func (l *Logger) isLevelHigherThanDefault(currentLevel level) bool {
-	return sortedLevels[currentLevel] >= sortedLevels[l.level]
+	return currentLevel.severity() >= l.level.load().severity()
 }
 
-func (l *Logger) getPrefix(level level, callInfo callInfo) (data []byte) {
-	data = append(data, l.getDateTime()...)
+func (l *Logger) getPrefix(r *record) (data []byte) {
+	data = append(data, l.getDateTime(r.time)...)
 	data = append(data, l.getTitle()...)
-	data = append(data, l.getLevel(level)...)
-	data = append(data, l.getCallerInfo(callInfo)...)
+	data = append(data, l.getLevel(r.level)...)
+	data = append(data, l.getCallerInfo(r.callInfo)...)
+	data = append(data, l.getFields(r.fields)...)
+	return data
+}
+
+func (l *Logger) getFields(fields []field) (data []byte) {
+	for _, f := range fields {
+		if l.flag&Labels != 0 {
+			data = append(data, fmt.Sprintf("%s = %v", strings.ToUpper(f.key), f.value)...)
+		} else {
+			data = append(data, fmt.Sprintf("%s=%v", f.key, f.value)...)
+		}
+		data = append(data, fmt.Sprintf(" %s ", l.separator)...)
+	}
 	return data
 }
 
@@ -116,7 +210,9 @@ func (l *Logger) getLevel(level level) (data []byte) {
 }
 
 func (l *Logger) resetLevel() {
-	l.level = l.originalLevel
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	l.level.store(l.originalLevel)
 }
 
 func (l *Logger) getTitle() (data []byte) {
@@ -129,8 +225,7 @@ func (l *Logger) getTitle() (data []byte) {
 	return data
 }
 
-func (l *Logger) getDateTime() (data []byte) {
-	t := time.Now()
+func (l *Logger) getDateTime(t time.Time) (data []byte) {
 	if l.flag&Date != 0 {
 		if l.flag&Labels != 0 {
 			data = append(data, "DATE = "...)
@@ -149,31 +244,115 @@ func (l *Logger) getDateTime() (data []byte) {
 	return data
 }
 
-func (l *Logger) log(level level, msg string) {
-	if !l.isLevelHigherThanDefault(level) {
+func (l *Logger) log(level level, msg string, kv ...interface{}) {
+	if !l.enabled(level) {
 		return
 	}
+	l.output(&record{
+		time:     time.Now(),
+		level:    level,
+		msg:      msg,
+		callInfo: getCallInfo(),
+		fields:   fieldsFromKV(kv),
+	})
+}
 
-	data := make([]byte, 0)
-	data = append(l.getPrefix(level, getCallInfo()), data...)
-	if l.flag&Labels != 0 {
-		data = append(data, "MSG = "...)
+// output writes r, which already passed the level check, prepending the
+// fields of l to the ones of r.
+func (l *Logger) output(r *record) {
+	c := l.root()
+	c.mu.RLock()
+	lm := c.limiter
+	c.mu.RUnlock()
+	if lm != nil && !lm.allow(c, r) {
+		return
 	}
 
-	if len(msg) == 0 {
-		data = append(data, "Unknown error\n"...)
-	} else {
-		data = append(data, msg...)
-		if msg[len(msg)-1] != '\n' {
-			data = append(data, '\n')
+	r.fields = mergeFields(l.fields, r.fields)
+	c.mu.RLock()
+	dedup := c.flag&Dedup != 0
+	c.mu.RUnlock()
+	if dedup && !c.dedup.allow(c, r) {
+		return
+	}
+	c.write(r)
+}
+
+// recordWriter is implemented by writers that take records rather than
+// encoded lines.
+type recordWriter interface {
+	writeRecord(l *Logger, r *record) error
+}
+
+// write sends r to the writer and the matching sinks of l. Writers are read
+// while holding writeMu, so a writer replaced and closed under writeMu is
+// never written to.
+func (l *Logger) write(r *record) {
+	for a := l; a != nil; {
+		a = a.writeAs(l, r)
+	}
+}
+
+// writeAs writes r, encoded with the settings of src, to the writer and the
+// matching sinks of l. It returns the ancestor l writes through as well.
+func (l *Logger) writeAs(src *Logger, r *record) (writers *Logger) {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if src != l {
+		src.mu.RLock()
+		defer src.mu.RUnlock()
+	}
+
+	var cache encodedCache
+	if l.w != nil {
+		src.writeTo(l.w, src.getEncoder(), r, &cache)
+	}
+	for _, s := range l.sinks {
+		if r.level.severity() >= s.level.severity() {
+			src.writeTo(s.w, s.getEncoder(src), r, &cache)
+		}
+	}
+	return l.writers
+}
+
+func (l *Logger) writeTo(w io.Writer, enc encoder, r *record, cache *encodedCache) {
+	if rw, ok := w.(recordWriter); ok {
+		_ = rw.writeRecord(l, r)
+		return
+	}
+	_, _ = w.Write(cache.encode(l, enc, r))
+}
+
+// encodedCache keeps the encodings of one record so that writers sharing a
+// format encode it only once.
+type encodedCache []struct {
+	enc  encoder
+	data []byte
+}
+
+func (c *encodedCache) encode(l *Logger, enc encoder, r *record) []byte {
+	for _, e := range *c {
+		if e.enc == enc {
+			return e.data
 		}
 	}
-	_, _ = l.w.Write(data)
+	data := enc.encode(l, r)
+	*c = append(*c, struct {
+		enc  encoder
+		data []byte
+	}{enc, data})
+	return data
 }
 
 func (l *Logger) getMsgFromError(err error, s []string) (msg string) {
+	l.mu.RLock()
+	separator := l.separator
+	l.mu.RUnlock()
+
 	parts := append([]string{err.Error()}, s...)
-	msg = strings.Join(parts, " "+l.separator+" ")
+	msg = strings.Join(parts, " "+separator+" ")
 	if t, ok := err.(TraceableError); ok {
 		msg = fmt.Sprintf("%s\n%s", msg, t.GetTrace())
 	}
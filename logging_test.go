@@ -1,12 +1,22 @@
 package logging
 
 import (
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -28,7 +38,7 @@ func TestNewFromConfig_Default(t *testing.T) {
 
 	assert.Equal(t, "test", l.title)
 	assert.Equal(t, "--", l.separator)
-	assert.Equal(t, WARNING, l.level)
+	assert.Equal(t, WARNING, l.level.load())
 	assert.Equal(t, os.Stdout, l.w)
 	assert.Equal(t, 0, l.flag)
 }
@@ -49,7 +59,7 @@ func TestNewFromConfig(t *testing.T) {
 	l := NewFromConfig(cfg)
 	assert.Equal(t, cfg.Title, l.title)
 	assert.Equal(t, cfg.Separator, l.separator)
-	assert.Equal(t, cfg.Level, l.level)
+	assert.Equal(t, cfg.Level, l.level.load())
 	assert.Equal(t, os.Stderr, l.w)
 	assert.Equal(t, Date|Time|Caller|Labels|ShortCaller, l.flag)
 
@@ -91,10 +101,10 @@ func TestResetLevel(t *testing.T) {
 	l := NewDefault(loggerName, ERROR)
 	_ = AddLogger(l)
 	l.SetLevel(DEBUG)
-	assert.Equal(t, l.level, DEBUG)
+	assert.Equal(t, l.level.load(), DEBUG)
 	err := ResetLevel(loggerName)
 	assert.Equal(t, err, nil)
-	assert.Equal(t, l.level, ERROR)
+	assert.Equal(t, l.level.load(), ERROR)
 	err = ResetLevel(wrongName)
 	assert.Equal(t, err.Error(), fmt.Sprintf("logger with name %s does not exists", wrongName))
 }
@@ -151,7 +161,7 @@ func TestSetLevelForLogger(t *testing.T) {
 	err = SetLevelForLogger(title, "iNfO")
 	assert.Equal(t, err, nil)
 	logger, _ := GetLogger(title)
-	assert.Equal(t, logger.level, INFO)
+	assert.Equal(t, logger.level.load(), INFO)
 }
 
 func TestGetLevelInt(t *testing.T) {
@@ -167,11 +177,11 @@ func TestResetLevels(t *testing.T) {
 	_ = AddLogger(l2)
 	l1.SetLevel(WARNING)
 	l2.SetLevel(WARNING)
-	assert.Equal(t, l1.level, WARNING)
-	assert.Equal(t, l2.level, WARNING)
+	assert.Equal(t, l1.level.load(), WARNING)
+	assert.Equal(t, l2.level.load(), WARNING)
 	ResetLevels()
-	assert.Equal(t, l1.level, ERROR)
-	assert.Equal(t, l2.level, INFO)
+	assert.Equal(t, l1.level.load(), ERROR)
+	assert.Equal(t, l2.level.load(), INFO)
 }
 
 func TestSetLevelForAll(t *testing.T) {
@@ -182,8 +192,8 @@ func TestSetLevelForAll(t *testing.T) {
 	_ = AddLogger(l2)
 	err := SetLevelForAll("WArNING")
 	assert.Equal(t, err, nil)
-	assert.Equal(t, l1.level, WARNING)
-	assert.Equal(t, l2.level, WARNING)
+	assert.Equal(t, l1.level.load(), WARNING)
+	assert.Equal(t, l2.level.load(), WARNING)
 	err = SetLevelForAll("inValid")
 	assert.Equal(t, err.Error(), fmt.Sprintf("level %s invalid", "INVALID"))
 }
@@ -193,24 +203,25 @@ func TestNewDefault(t *testing.T) {
 
 	l = NewDefault("")
 	assert.Equal(t, os.Stdout, l.w)
-	assert.Equal(t, WARNING, l.level)
+	assert.Equal(t, WARNING, l.level.load())
 
 	t.Setenv("DEBUG", "1")
 	l = NewDefault("")
-	assert.Equal(t, DEBUG, l.level)
+	assert.Equal(t, DEBUG, l.level.load())
 	l = NewDefault("", ERROR)
-	assert.Equal(t, DEBUG, l.level)
+	assert.Equal(t, DEBUG, l.level.load())
 
 	t.Setenv("DEBUG", "")
 	l = NewDefault("")
-	assert.Equal(t, WARNING, l.level)
+	assert.Equal(t, WARNING, l.level.load())
 	l = NewDefault("", FATAL)
-	assert.Equal(t, FATAL, l.level)
+	assert.Equal(t, FATAL, l.level.load())
 }
 
 func TestSetSeparator(t *testing.T) {
 	w := WriterMock{}
-	l := Logger{level: DEBUG, w: &w}
+	l := Logger{w: &w}
+	l.SetLevel(DEBUG)
 	msg := "asdasd"
 
 	w.On("Write", []byte(fmt.Sprintf("[WARNING]  %s\n", msg)))
@@ -266,11 +277,11 @@ func TestUnsetFlags(t *testing.T) {
 
 func TestIsLevelHigherThanDefault(t *testing.T) {
 	l := Logger{}
-	l.level = FATAL
+	l.level.store(FATAL)
 	assert.False(t, l.isLevelHigherThanDefault(DEBUG))
-	l.level = DEBUG
+	l.level.store(DEBUG)
 	assert.True(t, l.isLevelHigherThanDefault(DEBUG))
-	l.level = DEBUG
+	l.level.store(DEBUG)
 	assert.True(t, l.isLevelHigherThanDefault(FATAL))
 }
 
@@ -320,7 +331,8 @@ func TestDifferentLogLevels(t *testing.T) {
 	w.On("Write", []byte("[ERROR]  msg\n"))
 	w.On("Write", []byte("[INFO]  msg\n"))
 
-	l := Logger{level: WARNING, w: &w}
+	l := Logger{w: &w}
+	l.SetLevel(WARNING)
 	l.Warning("msg")
 	l.Debug("msg")
 	l.Error("msg")
@@ -471,3 +483,1899 @@ func TestTraceableErrUnwrapping(t *testing.T) {
 	assert.True(t, errors.Is(trErrD, NotFoundBaseErr))
 	assert.Equal(t, trErrD.Error(), fmt.Sprintf("%s: data with id '10'", NotFoundBaseErr))
 }
+
+func TestGetFields(t *testing.T) {
+	l := New(&WriterMock{}, "test", 0, DEBUG, DefaultSeparator)
+	fields := fieldsFromKV([]interface{}{"user", 42, "req", "abc"})
+	assert.Equal(t, "user=42 -- req=abc -- ", string(l.getFields(fields)))
+	l.SetFlags(Labels)
+	assert.Equal(t, "USER = 42 -- REQ = abc -- ", string(l.getFields(fields)))
+	assert.Equal(t, []field{{key: "user"}}, fieldsFromKV([]interface{}{"user"}))
+}
+
+func TestLogKV(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "test", 0, DEBUG, DefaultSeparator)
+
+	w.On("Write", []byte("(test) -- [INFO] -- user=42 -- some info\n"))
+	w.On("Write", []byte("(test) -- [ERROR] -- user=42 -- code=500 -- some error\n"))
+	w.On("Write", []byte("TITLE = (test) -- LEVEL = [WARNING] -- USER = 42 -- MSG = some warning\n"))
+
+	l.InfoKV("some info", "user", 42)
+	l.ErrorKV("some error", "user", 42, "code", 500)
+	l.SetFlags(Labels)
+	l.WarningKV("some warning", "user", 42)
+	w.AssertExpectations(t)
+	w.AssertNumberOfCalls(t, "Write", 3)
+}
+
+func TestWith(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "test", 0, WARNING, DefaultSeparator)
+	child := l.With("user", 42)
+	grandChild := child.With("req", "abc")
+
+	w.On("Write", []byte("(test) -- [WARNING] -- user=42 -- some warning\n"))
+	w.On("Write", []byte("(test) -- [ERROR] -- user=42 -- req=abc -- code=500 -- some error\n"))
+	w.On("Write", []byte("(test) -- [INFO] -- some info\n"))
+
+	child.Warning("some warning")
+	child.Info("filtered out")
+	grandChild.ErrorKV("some error", "code", 500)
+	child.SetLevel(INFO)
+	assert.Equal(t, INFO, l.level.load())
+	l.Info("some info")
+	assert.Equal(t, w, grandChild.GetWriter())
+	w.AssertExpectations(t)
+	w.AssertNumberOfCalls(t, "Write", 3)
+}
+
+func TestWith_OverriddenKeys(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator).SetFormat(JSONFormat)
+	child := l.With("a", 1, "b", 1)
+	child.InfoKV("m", "a", 2)
+	child.With("b", 2).Info("m")
+	assert.Equal(t, []string{
+		`{"level":"INFO","msg":"m","b":1,"a":2}` + "\n",
+		`{"level":"INFO","msg":"m","a":1,"b":2}` + "\n",
+	}, w.lines)
+}
+
+func TestJSONFormat(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "test", 0, DEBUG, DefaultSeparator).SetFormat(JSONFormat)
+
+	w.On("Write", []byte(`{"level":"INFO","title":"test","msg":"some \"info\""}`+"\n"))
+	w.On("Write", []byte(`{"level":"ERROR","title":"test","msg":"Unknown error","user":42,"err":"boom"}`+"\n"))
+	w.On("Write", []byte(`{"level":"WARNING","title":"test","caller":"null:42","msg":"line"}`+"\n"))
+
+	l.Info(`some "info"`)
+	l.ErrorKV("", "user", 42, "err", errors.New("boom"))
+
+	l.SetFlags(ShortCaller)
+	caller = func(i int) (pc uintptr, file string, line int, ok bool) {
+		return 0, "/dev/null", 42, true
+	}
+	defer func() { caller = runtime.Caller }()
+	l.Warning("line\n")
+
+	w.AssertExpectations(t)
+	w.AssertNumberOfCalls(t, "Write", 3)
+}
+
+func TestStructuredFormats_ReservedKeys(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "api", 0, DEBUG, DefaultSeparator).SetFormat(JSONFormat)
+	l.InfoKV("real", "msg", "override", "level", "x", "title", "t", "user", 42)
+	assert.Equal(t, []string{`{"level":"INFO","title":"api","msg":"real","fields.msg":"override","fields.level":"x","fields.title":"t","user":42}` + "\n"}, w.lines)
+
+	w.lines = nil
+	l.SetFormat(LogfmtFormat).InfoKV("real", "msg", "override", "time", "now")
+	assert.Equal(t, []string{"level=INFO title=api msg=real fields.msg=override fields.time=now\n"}, w.lines)
+}
+
+func TestRecordTime(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	assert.Equal(t, "", recordTime(0, now))
+	assert.Equal(t, "2021-03-04", recordTime(Date, now))
+	assert.Equal(t, "05:06:07", recordTime(Time, now))
+	assert.Equal(t, "2021-03-04T05:06:07Z", recordTime(Date|Time, now))
+}
+
+func TestNewFromConfig_Format(t *testing.T) {
+	l := NewFromConfig(Config{Title: "test"})
+	assert.Equal(t, textEncoder{}, l.getEncoder())
+	l = NewFromConfig(Config{Title: "test", Format: "JSON"})
+	assert.Equal(t, jsonEncoder{}, l.getEncoder())
+	l = NewFromConfig(Config{Title: "test", Format: "unknown"})
+	assert.Equal(t, textEncoder{}, l.getEncoder())
+}
+
+func TestLogfmtFormat(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "api", 0, DEBUG, DefaultSeparator).SetFormat(LogfmtFormat)
+
+	w.On("Write", []byte(`level=INFO title=api msg=started`+"\n"))
+	w.On("Write", []byte(`level=ERROR title=api msg="request \"failed\"\nretrying" user_id=42 path="/a b" empty=""`+"\n"))
+
+	l.Info("started")
+	l.ErrorKV("request \"failed\"\nretrying", "user id", 42, "path", "/a b", "empty", "")
+	w.AssertExpectations(t)
+	w.AssertNumberOfCalls(t, "Write", 2)
+
+	l = NewFromConfig(Config{Title: "test", Format: LogfmtFormat})
+	assert.Equal(t, logfmtEncoder{}, l.getEncoder())
+}
+
+func TestLogfmtValue(t *testing.T) {
+	assert.Equal(t, "plain", logfmtValue("plain"))
+	assert.Equal(t, `""`, logfmtValue(""))
+	assert.Equal(t, `"a=b"`, logfmtValue("a=b"))
+	assert.Equal(t, `"back\\slash"`, logfmtValue(`back\slash`))
+	assert.Equal(t, `"tab\there"`, logfmtValue("tab\there"))
+	assert.Equal(t, `"bell\u0007"`, logfmtValue("bell\a"))
+	assert.Equal(t, "_", logfmtKey(""))
+	assert.Equal(t, "a_b_c", logfmtKey("a b=c"))
+}
+
+type lineWriter struct {
+	lines []string
+}
+
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	w.lines = append(w.lines, string(p))
+	return len(p), nil
+}
+
+func TestLoggerConcurrentUse(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "test", 0, DEBUG, DefaultSeparator)
+	child := l.With("worker", true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				child.Info("message")
+				l.ErrorKV("failure", "i", i)
+				l.LogError(errors.New("some error"))
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 200; j++ {
+			l.SetLevel(DEBUG)
+			l.SetFlags(Labels | Time)
+			l.SetSeparator("|")
+			l.UnsetFlags(Labels)
+			l.SetFormat(JSONFormat)
+			l.SetFormat(TextFormat)
+			l.SetWriter(w)
+			_ = l.GetWriter()
+			_ = l.GetLevelInt()
+		}
+	}()
+
+	registry.clear()
+	_ = AddLogger(l)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 200; j++ {
+			_ = SetLevelForAll("debug")
+			_ = SetLevelForLogger("test", "info")
+			ResetLevels()
+		}
+	}()
+	wg.Wait()
+
+	for _, line := range w.lines {
+		assert.Equal(t, 1, strings.Count(line, "\n"))
+	}
+}
+
+type stringerMock struct {
+	calls int
+}
+
+func (s *stringerMock) String() string {
+	s.calls++
+	return "formatted"
+}
+
+func TestLogf(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "test", 0, WARNING, DefaultSeparator)
+	exit = func(i int) {}
+
+	w.On("Write", []byte("(test) -- [WARNING] -- warning 1 formatted\n"))
+	w.On("Write", []byte("(test) -- [ERROR] -- error 2\n"))
+	w.On("Write", []byte("(test) -- [FATAL] -- fatal 3\n"))
+	w.On("Write", []byte("(test) -- [ERROR] -- some error -- while doing 4\n"))
+	w.On("Write", []byte("(test) -- [FATAL] -- some error -- while doing 5\n"))
+
+	s := &stringerMock{}
+	l.Debugf("debug %d %s", 0, s)
+	l.Infof("info %d %s", 0, s)
+	assert.Equal(t, 0, s.calls)
+	l.Warningf("warning %d %s", 1, s)
+	assert.Equal(t, 1, s.calls)
+	l.Errorf("error %d", 2)
+	l.Fatalf("fatal %d", 3)
+
+	err := errors.New("some error")
+	l.LogErrorf(nil, "while doing %d", 0)
+	l.LogErrorf(err, "while doing %d", 4)
+	l.LogFatalf(nil, "while doing %d", 0)
+	l.LogFatalf(err, "while doing %d", 5)
+	l.SetLevel(FATAL)
+	l.LogErrorf(err, "while doing %s", s)
+	assert.Equal(t, 1, s.calls)
+
+	w.AssertExpectations(t)
+	w.AssertNumberOfCalls(t, "Write", 5)
+}
+
+func TestCallerIsReportedForEveryMethod(t *testing.T) {
+	caller = runtime.Caller
+	exit = func(i int) {}
+	w := &lineWriter{}
+	l := New(w, "", ShortCaller, DEBUG, DefaultSeparator)
+	err := errors.New("e")
+
+	l.Info("m")
+	l.InfoKV("m", "k", "v")
+	l.Infof("m")
+	l.Warningf("m")
+	l.Debugf("m")
+	l.Errorf("m")
+	l.Fatalf("m")
+	l.LogError(err)
+	l.LogErrorf(err, "m")
+	l.LogFatal(err)
+	l.LogFatalf(err, "m")
+	l.With("k", "v").Warning("m")
+	l.InfoCtx(context.Background(), "m")
+	l.LogErrorCtx(context.Background(), err)
+
+	assert.Equal(t, 14, len(w.lines))
+	for _, line := range w.lines {
+		assert.Contains(t, line, "-- logging_test.go:")
+	}
+}
+
+func TestRotatingFile_Size(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	rf, err := NewRotatingFile(path, 1, 2, 0, false)
+	assert.Nil(t, err)
+	rf.maxSize = 10
+	defer func() { _ = rf.Close() }()
+
+	for i := 0; i < 4; i++ {
+		_, err = rf.Write([]byte("12345678\n"))
+		assert.Nil(t, err)
+		rf.cleanups.Wait()
+		clock = clock.Add(time.Second)
+	}
+
+	backups, err := rf.backups()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(backups))
+	assert.Equal(t, filepath.Join(dir, "app-2021-03-04T05-06-10.000.log"), backups[0].path)
+	assert.Equal(t, filepath.Join(dir, "app-2021-03-04T05-06-09.000.log"), backups[1].path)
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "12345678\n", string(content))
+}
+
+func TestRotatingFile_AgeAndCompress(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	rf, err := NewRotatingFile(path, 0, 0, 1, true)
+	assert.Nil(t, err)
+	defer func() { _ = rf.Close() }()
+
+	_, _ = rf.Write([]byte("first\n"))
+	clock = clock.Add(25 * time.Hour)
+	_, _ = rf.Write([]byte("second\n"))
+	rf.cleanups.Wait()
+
+	backups, _ := rf.backups()
+	assert.Equal(t, 1, len(backups))
+	assert.True(t, strings.HasSuffix(backups[0].path, ".log.gz"))
+	f, _ := os.Open(backups[0].path)
+	gz, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+	content, _ := io.ReadAll(gz)
+	_ = f.Close()
+	assert.Equal(t, "first\n", string(content))
+
+	// the first backup is now older than max age and gets removed
+	clock = clock.Add(25 * time.Hour)
+	_, _ = rf.Write([]byte("third\n"))
+	rf.cleanups.Wait()
+	backups, _ = rf.backups()
+	assert.Equal(t, 1, len(backups))
+	assert.Equal(t, filepath.Join(dir, "app-2021-03-06T07-06-07.000.log.gz"), backups[0].path)
+}
+
+func TestRotatingFile_CleanupErrorKeepsLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	rf, err := NewRotatingFile(path, 1, 0, 0, true)
+	assert.Nil(t, err)
+	rf.maxSize = 10
+	defer func() { _ = rf.Close() }()
+
+	_, err = rf.Write([]byte("12345678\n"))
+	assert.Nil(t, err)
+	// the active file is moved away, so compressing the backup fails
+	assert.Nil(t, os.Rename(path, filepath.Join(dir, "moved")))
+	n, err := rf.Write([]byte("kept\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "kept\n", string(content))
+}
+
+func TestRotatingFile_BackgroundCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	rf, err := NewRotatingFile(path, 1, 0, 0, true)
+	assert.Nil(t, err)
+	rf.maxSize = 10
+
+	// a cleanup in progress doesn't hold up writes
+	rf.cleanupMu.Lock()
+	_, err = rf.Write([]byte("12345678\n"))
+	assert.Nil(t, err)
+	_, err = rf.Write([]byte("12345678\n"))
+	assert.Nil(t, err)
+	_, err = rf.Write([]byte("12345678\n"))
+	assert.Nil(t, err)
+	backups, _ := rf.backups()
+	assert.Equal(t, 2, len(backups))
+	for _, b := range backups {
+		assert.False(t, strings.HasSuffix(b.path, compressSuffix))
+	}
+	rf.cleanupMu.Unlock()
+
+	// Close waits for the backups to be compressed
+	assert.Nil(t, rf.Close())
+	backups, _ = rf.backups()
+	assert.Equal(t, 2, len(backups))
+	for _, b := range backups {
+		assert.True(t, strings.HasSuffix(b.path, compressSuffix))
+	}
+}
+
+func TestRotatingFile_RetryDelay(t *testing.T) {
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	rf := &RotatingFile{maxSize: 10, size: 10, retryAt: clock.Add(rotateRetryDelay)}
+	assert.False(t, rf.needsRotation(1))
+	clock = clock.Add(rotateRetryDelay)
+	assert.True(t, rf.needsRotation(1))
+}
+
+func TestRotatingFile_AgeSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	assert.Nil(t, os.WriteFile(path, []byte("old\n"), defaultFileMode))
+	assert.Nil(t, os.Chtimes(path, clock, clock.Add(-2*day)))
+	rf, err := NewRotatingFile(path, 0, 0, 1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, clock.Add(-2*day), rf.openedAt)
+	_, _ = rf.Write([]byte("new\n"))
+	rf.cleanups.Wait()
+	backups, _ := rf.backups()
+	assert.Equal(t, 1, len(backups))
+	assert.Nil(t, rf.Close())
+
+	// reopened later, the age is counted from the last rotation
+	clock = clock.Add(12 * time.Hour)
+	assert.Nil(t, os.Chtimes(path, clock, clock))
+	rf, err = NewRotatingFile(path, 0, 0, 1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, backups[0].time, rf.openedAt)
+	assert.Nil(t, rf.Close())
+}
+
+func TestNewFromConfig_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	l := NewFromConfig(Config{Title: "test", Direction: path, MaxSizeMB: 10, Compress: true})
+	rf, ok := l.w.(*RotatingFile)
+	assert.True(t, ok)
+	assert.Equal(t, int64(10*megabyte), rf.maxSize)
+	assert.True(t, rf.compress)
+	assert.Nil(t, rf.Close())
+}
+
+func TestNewFromConfigE(t *testing.T) {
+	l, err := NewFromConfigE(Config{Title: "test", Level: "error", Format: JSONFormat})
+	assert.Nil(t, err)
+	assert.Equal(t, ERROR, l.level.load())
+
+	l, err = NewFromConfigE(Config{
+		Title:             "test",
+		Level:             "verbose",
+		Format:            "xml",
+		Direction:         filepath.Join(t.TempDir(), "missing", "test.log"),
+		EnableCaller:      true,
+		EnableShortCaller: true,
+	})
+	assert.Nil(t, l)
+	assert.NotNil(t, err)
+	msg := err.Error()
+	assert.True(t, strings.HasPrefix(msg, "invalid config for logger test: "))
+	assert.Contains(t, msg, "no such file or directory")
+	assert.Contains(t, msg, "level VERBOSE invalid")
+	assert.Contains(t, msg, "format xml invalid")
+	assert.Contains(t, msg, "enable_caller and enable_short_caller are mutually exclusive")
+
+	var errs multiError
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 4, len(errs))
+}
+
+func TestAddLoggerFromConfig_Invalid(t *testing.T) {
+	registry.clear()
+	_, err := AddLoggerFromConfig(Config{Title: "test", Level: "verbose"})
+	assert.Equal(t, "invalid config for logger test: level VERBOSE invalid", err.Error())
+	_, err = GetLogger("test")
+	assert.NotNil(t, err)
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfigFile_YAML(t *testing.T) {
+	registry.clear()
+	path := writeConfigFile(t, "logging.yaml", `
+defaults:
+  separator: "|"
+  level: error
+  enable_time: true
+loggers:
+  - title: api
+    level: debug
+  - title: db
+    enable_time: false
+    format: json
+`)
+	assert.Nil(t, LoadConfigFile(path))
+
+	api, err := GetLogger("api")
+	assert.Nil(t, err)
+	assert.Equal(t, DEBUG, api.level.load())
+	assert.Equal(t, "|", api.separator)
+	assert.Equal(t, Time, api.flag)
+
+	db, err := GetLogger("db")
+	assert.Nil(t, err)
+	assert.Equal(t, ERROR, db.level.load())
+	assert.Equal(t, 0, db.flag)
+	assert.Equal(t, jsonEncoder{}, db.getEncoder())
+}
+
+func TestLoadConfigFile_JSON(t *testing.T) {
+	registry.clear()
+	path := writeConfigFile(t, "logging.json", `{
+		"loggers": [
+			{"title": "api", "level": "info"},
+			{"title": "bad", "level": "verbose"},
+			{"level": "info"},
+			{"title": "flags", "enable_time": "maybe"},
+			{"title": "api"}
+		]
+	}`)
+	err := LoadConfigFile(path)
+	var errs multiError
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 4, len(errs))
+	assert.Contains(t, err.Error(), "loggers[2]: title is required")
+	assert.Contains(t, err.Error(), "loggers[3]: ")
+	assert.Contains(t, err.Error(), "invalid config for logger bad: level VERBOSE invalid")
+	assert.Contains(t, err.Error(), "logger with name api already exists")
+
+	api, err := GetLogger("api")
+	assert.Nil(t, err)
+	assert.Equal(t, INFO, api.level.load())
+	_, err = GetLogger("bad")
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigFile_Errors(t *testing.T) {
+	assert.NotNil(t, LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")))
+	path := writeConfigFile(t, "broken.yaml", "loggers: [")
+	assert.Contains(t, LoadConfigFile(path).Error(), "parse "+path)
+}
+
+func TestApplyConfig(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewFromConfigE(Config{Title: "api", Level: ERROR})
+	assert.Nil(t, err)
+
+	l.SetLevel(DEBUG)
+	changed, err := l.applyConfig(Config{Title: "api", Level: "error", Separator: "--"})
+	assert.Nil(t, err)
+	assert.Nil(t, changed)
+	assert.Equal(t, DEBUG, l.level.load())
+
+	path := filepath.Join(dir, "api.log")
+	changed, err = l.applyConfig(Config{
+		Title:      "api",
+		Level:      INFO,
+		Separator:  "|",
+		Direction:  path,
+		Format:     JSONFormat,
+		EnableTime: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"writer", "level", "separator", "flags", "format"}, changed)
+	assert.Equal(t, INFO, l.level.load())
+	assert.Equal(t, INFO, l.originalLevel)
+	assert.Equal(t, "|", l.separator)
+	assert.Equal(t, Time, l.flag)
+	assert.Equal(t, jsonEncoder{}, l.getEncoder())
+	f := l.w.(*os.File)
+	assert.Equal(t, path, f.Name())
+
+	changed, err = l.applyConfig(Config{Title: "api", Level: "verbose", Direction: "stderr"})
+	assert.Equal(t, "invalid config for logger api: level VERBOSE invalid", err.Error())
+	assert.Nil(t, changed)
+	assert.Equal(t, f, l.w)
+
+	changed, err = l.applyConfig(Config{Title: "api", Level: INFO, Separator: "|", Format: JSONFormat, EnableTime: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"writer"}, changed)
+	assert.Equal(t, os.Stdout, l.w)
+	assert.NotNil(t, f.Close(), "file should have been closed")
+}
+
+func TestApplyConfig_CodeBuiltLogger(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "api", Time, ERROR, DefaultSeparator)
+	changed, err := l.applyConfig(Config{Title: "api", Level: DEBUG, EnableTime: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"level"}, changed)
+	assert.Equal(t, w, l.w)
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	registry.clear()
+	path := writeConfigFile(t, "logging.yaml", `
+loggers:
+  - title: api
+    level: error
+`)
+	changes := make(chan []ConfigChange, 10)
+	errs := make(chan error, 10)
+	stop, err := WatchConfigFile(path, 10*time.Millisecond, func(c []ConfigChange, err error) {
+		if err != nil {
+			errs <- err
+		}
+		changes <- c
+	})
+	assert.Nil(t, err)
+	defer stop()
+
+	api, err := GetLogger("api")
+	assert.Nil(t, err)
+	assert.Equal(t, ERROR, api.level.load())
+
+	assert.Nil(t, os.WriteFile(path, []byte(`
+loggers:
+  - title: api
+    level: debug
+  - title: db
+  - title: bad
+    level: verbose
+`), 0644))
+
+	select {
+	case c := <-changes:
+		assert.Equal(t, []ConfigChange{
+			{Logger: "api", Changed: []string{"level"}},
+			{Logger: "db", Added: true},
+		}, c)
+		assert.Equal(t, "invalid config for logger bad: level VERBOSE invalid", (<-errs).Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change was not reported")
+	}
+	assert.Equal(t, DEBUG, api.level.load())
+	_, err = GetLogger("db")
+	assert.Nil(t, err)
+
+	_, err = WatchConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), time.Second, nil)
+	assert.NotNil(t, err)
+	stop, err = WatchConfigFile(path, 0, nil)
+	assert.Nil(t, stop)
+	assert.Equal(t, "interval 0s invalid", err.Error())
+	_, err = WatchConfigFile(path, -time.Second, nil)
+	assert.Equal(t, "interval -1s invalid", err.Error())
+}
+
+func adminRequest(t *testing.T, method, body string) (int, string) {
+	req := httptest.NewRequest(method, "/admin/loggers", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestAdminHandler(t *testing.T) {
+	registry.clear()
+	_ = AddLogger(New(os.Stderr, "db", Time|ShortCaller, ERROR, DefaultSeparator))
+	_ = AddLogger(New(&WriterMock{}, "api", 0, WARNING, DefaultSeparator))
+
+	code, body := adminRequest(t, http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[
+		{"name": "api", "level": "WARNING", "original_level": "WARNING", "flags": [], "writer": "*logging.WriterMock"},
+		{"name": "db", "level": "ERROR", "original_level": "ERROR", "flags": ["TIME", "SHORT_CALLER"], "writer": "*os.File"}
+	]`, body)
+
+	code, _ = adminRequest(t, http.MethodPut, `{"logger": "api", "level": "debug"}`)
+	assert.Equal(t, http.StatusOK, code)
+	api, _ := GetLogger("api")
+	db, _ := GetLogger("db")
+	assert.Equal(t, DEBUG, api.level.load())
+
+	code, _ = adminRequest(t, http.MethodPost, `{"level": "info"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, INFO, api.level.load())
+	assert.Equal(t, INFO, db.level.load())
+
+	code, _ = adminRequest(t, http.MethodPut, `{"logger": "db", "reset": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ERROR, db.level.load())
+	assert.Equal(t, INFO, api.level.load())
+
+	code, body = adminRequest(t, http.MethodPut, `{"reset": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"api","level":"WARNING"`)
+	assert.Equal(t, WARNING, api.level.load())
+}
+
+func TestAdminHandler_Errors(t *testing.T) {
+	registry.clear()
+	_ = AddLogger(New(&WriterMock{}, "api", 0, WARNING, DefaultSeparator))
+
+	code, body := adminRequest(t, http.MethodPut, `{"logger": "db", "level": "debug"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.JSONEq(t, `{"error": "logger with name db does not exists"}`, body)
+
+	code, body = adminRequest(t, http.MethodPut, `{"logger": "api", "level": "verbose"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.JSONEq(t, `{"error": "level VERBOSE invalid"}`, body)
+
+	code, _ = adminRequest(t, http.MethodPut, `{"logger": "api", "level": "debug", "reset": true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = adminRequest(t, http.MethodPut, `{"logger": "api"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = adminRequest(t, http.MethodPut, `not json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = adminRequest(t, http.MethodDelete, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestContext(t *testing.T) {
+	w := &WriterMock{}
+	l := New(w, "api", 0, DEBUG, DefaultSeparator)
+
+	ctx := context.Background()
+	assert.Equal(t, "", RequestID(ctx))
+	fallback := FromContext(ctx)
+	assert.Equal(t, os.Stdout, fallback.GetWriter())
+
+	ctx = NewContext(ctx, l)
+	assert.Equal(t, l, FromContext(ctx))
+
+	ctx = WithUserID(WithTraceID(WithRequestID(ctx, "req-1"), "trace-1"), "user-1")
+	assert.Equal(t, "req-1", RequestID(ctx))
+	assert.Equal(t, "trace-1", TraceID(ctx))
+	assert.Equal(t, "user-1", UserID(ctx))
+
+	w.On("Write", []byte("(api) -- [INFO] -- request_id=req-1 -- trace_id=trace-1 -- user_id=user-1 -- handled\n"))
+	w.On("Write", []byte("(api) -- [WARNING] -- request_id=req-2 -- slow\n"))
+	w.On("Write", []byte("(api) -- [ERROR] -- component=db -- request_id=req-2 -- some error -- while saving\n"))
+
+	FromContext(ctx).Info("handled")
+	ctx = WithRequestID(context.Background(), "req-2")
+	l.WarningCtx(ctx, "slow")
+	l.With("component", "db").LogErrorCtx(ctx, errors.New("some error"), "while saving")
+	l.LogErrorCtx(ctx, nil)
+
+	w.AssertExpectations(t)
+	w.AssertNumberOfCalls(t, "Write", 3)
+
+	// a nil context carries nothing
+	var nilCtx context.Context
+	assert.Equal(t, os.Stdout, FromContext(nilCtx).GetWriter())
+	assert.Equal(t, "", RequestID(nilCtx))
+	assert.Equal(t, "", TraceID(nilCtx))
+	assert.Equal(t, "", UserID(nilCtx))
+}
+
+func TestSlogHandler(t *testing.T) {
+	caller = runtime.Caller
+	w := &lineWriter{}
+	l := New(w, "api", ShortCaller, INFO, DefaultSeparator)
+	logger := slog.New(NewSlogHandler(l))
+
+	assert.False(t, logger.Enabled(context.Background(), slog.LevelDebug))
+	assert.True(t, logger.Enabled(context.Background(), slog.LevelInfo))
+
+	logger.Debug("filtered out")
+	logger.Info("started", "port", 8080, slog.Group("db", "host", "localhost", slog.Group("pool", "size", 4)))
+	logger.With("component", "http").WithGroup("req").Warn("slow", "ms", 1500, slog.Attr{})
+	logger.Log(context.Background(), slog.LevelError+4, "fatal without exit")
+
+	assert.Equal(t, 3, len(w.lines))
+	assert.Regexp(t, `^\(api\) -- \[INFO\] -- logging_test.go:\d+ -- port=8080 -- db.host=localhost -- db.pool.size=4 -- started\n$`, w.lines[0])
+	assert.Regexp(t, `^\(api\) -- \[WARNING\] -- logging_test.go:\d+ -- component=http -- req.ms=1500 -- slow\n$`, w.lines[1])
+	assert.Regexp(t, `^\(api\) -- \[FATAL\] -- `, w.lines[2])
+}
+
+func TestSlogLevels(t *testing.T) {
+	assert.Equal(t, DEBUG, levelFromSlog(slog.LevelDebug-4))
+	assert.Equal(t, DEBUG, levelFromSlog(slog.LevelDebug))
+	assert.Equal(t, INFO, levelFromSlog(slog.LevelInfo))
+	assert.Equal(t, WARNING, levelFromSlog(slog.LevelWarn))
+	assert.Equal(t, ERROR, levelFromSlog(slog.LevelError))
+	assert.Equal(t, FATAL, levelFromSlog(slog.LevelError+4))
+	for _, lvl := range []level{DEBUG, INFO, WARNING, ERROR, FATAL} {
+		assert.Equal(t, lvl, levelFromSlog(levelToSlog(lvl)))
+	}
+}
+
+func TestSlogWriter(t *testing.T) {
+	caller = runtime.Caller
+	var buf strings.Builder
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		AddSource: true,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			if a.Key == slog.SourceKey {
+				src := a.Value.Any().(*slog.Source)
+				if src.File == "" {
+					return slog.Attr{}
+				}
+				return slog.String(slog.SourceKey, filepath.Base(src.File))
+			}
+			return a
+		},
+	})
+	l := New(NewSlogWriter(h), "api", 0, DEBUG, DefaultSeparator)
+
+	l.With("user", 42).ErrorKV("failed", "code", 500)
+	l.Debug("filtered out by the handler")
+	_, _ = l.GetWriter().Write([]byte("raw line\n"))
+
+	assert.Equal(t,
+		"level=ERROR source=logging_test.go msg=failed title=api user=42 code=500\n"+
+			"level=INFO msg=\"raw line\"\n",
+		buf.String())
+}
+
+func TestStdLogger(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "api", ShortCaller, INFO, DefaultSeparator)
+
+	std := l.StdLogger(ERROR)
+	std.Printf("connection %s", "reset")
+	std.Println("multi\nline")
+	l.StdLogger(DEBUG).Print("filtered out")
+
+	assert.Equal(t, 2, len(w.lines))
+	assert.Regexp(t, `^\(api\) -- \[ERROR\] -- logging_test.go:\d+ -- connection reset\n$`, w.lines[0])
+	assert.Regexp(t, `^\(api\) -- \[ERROR\] -- logging_test.go:\d+ -- multi\nline\n$`, w.lines[1])
+}
+
+func TestRedirectStdLog(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "std", ShortCaller, DEBUG, DefaultSeparator)
+
+	restore := RedirectStdLog(l, WARNING)
+	log.Print("from the standard logger")
+	restore()
+
+	assert.Equal(t, 1, len(w.lines))
+	assert.Regexp(t, `^\(std\) -- \[WARNING\] -- logging_test.go:\d+ -- from the standard logger\n$`, w.lines[0])
+	assert.Equal(t, log.LstdFlags, log.Flags())
+	assert.Equal(t, os.Stderr, log.Writer())
+}
+
+func TestSplitStdLine(t *testing.T) {
+	ci, msg := splitStdLine([]byte("C:/src/a: b/main.go:12: key: value\n"))
+	assert.Equal(t, callInfo{file: "C:/src/a: b/main.go", line: 12}, ci)
+	assert.Equal(t, "key: value\n", string(msg))
+
+	ci, msg = splitStdLine([]byte("no location: here"))
+	assert.Equal(t, callInfo{file: sourceErr, line: -1}, ci)
+	assert.Equal(t, "no location: here", string(msg))
+}
+
+func TestSinks(t *testing.T) {
+	primary, errSink, debugSink := &lineWriter{}, &lineWriter{}, &lineWriter{}
+	l := New(primary, "api", 0, INFO, DefaultSeparator).
+		AddSink(errSink, ERROR).
+		AddSink(debugSink, DEBUG, JSONFormat)
+
+	l.Debug("filtered by the logger level")
+	l.Info("some info")
+	l.Error("some error")
+
+	assert.Equal(t, []string{"(api) -- [INFO] -- some info\n", "(api) -- [ERROR] -- some error\n"}, primary.lines)
+	assert.Equal(t, []string{"(api) -- [ERROR] -- some error\n"}, errSink.lines)
+	assert.Equal(t, []string{
+		`{"level":"INFO","title":"api","msg":"some info"}` + "\n",
+		`{"level":"ERROR","title":"api","msg":"some error"}` + "\n",
+	}, debugSink.lines)
+
+	l.RemoveSinks()
+	l.Error("only primary")
+	assert.Equal(t, 1, len(errSink.lines))
+	assert.Equal(t, 3, len(primary.lines))
+
+	onlySinks := New(nil, "api", 0, DEBUG, DefaultSeparator).AddSink(errSink, WARNING)
+	onlySinks.Warning("to the sink")
+	assert.Equal(t, "(api) -- [WARNING] -- to the sink\n", errSink.lines[1])
+}
+
+func TestNewFromConfig_Sinks(t *testing.T) {
+	dir := t.TempDir()
+	debugPath := filepath.Join(dir, "debug.log")
+	cfg := Config{
+		Title: "api",
+		Level: DEBUG,
+		Sinks: []SinkConfig{
+			{Direction: "stderr", Level: "error"},
+			{Direction: debugPath, Level: DEBUG, Format: JSONFormat},
+		},
+	}
+	l, err := NewFromConfigE(cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, l.w)
+	assert.Equal(t, 2, len(l.sinks))
+	assert.Equal(t, os.Stderr, l.sinks[0].w)
+	assert.Equal(t, ERROR, l.sinks[0].level)
+	assert.Nil(t, l.sinks[0].enc)
+	assert.False(t, l.sinks[0].owned)
+	assert.Equal(t, jsonEncoder{}, l.sinks[1].enc)
+	assert.True(t, l.sinks[1].owned)
+
+	l.Debug("debug only")
+	content, _ := os.ReadFile(debugPath)
+	assert.Equal(t, `{"level":"DEBUG","title":"api","msg":"debug only"}`+"\n", string(content))
+
+	cfg.Direction = "stdout"
+	changed, err := l.applyConfig(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"writer"}, changed)
+	assert.Equal(t, os.Stdout, l.w)
+
+	cfg.Sinks = cfg.Sinks[:1]
+	changed, err = l.applyConfig(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sinks"}, changed)
+	assert.Equal(t, 1, len(l.sinks))
+
+	_, err = NewFromConfigE(Config{
+		Title: "bad",
+		Sinks: []SinkConfig{
+			{Level: "verbose", Format: "xml"},
+			{Direction: filepath.Join(dir, "missing", "x.log")},
+		},
+	})
+	assert.Contains(t, err.Error(), "sinks[0]: level VERBOSE invalid")
+	assert.Contains(t, err.Error(), "sinks[0]: format xml invalid")
+	assert.Contains(t, err.Error(), "sinks[1]: open ")
+
+	// every sink that can't be opened is reported, the others are kept
+	cfg = Config{
+		Title: "partial",
+		Sinks: []SinkConfig{
+			{Direction: filepath.Join(dir, "missing", "a.log")},
+			{Direction: "stderr"},
+			{Direction: filepath.Join(dir, "missing", "b.log")},
+		},
+	}
+	_, err = NewFromConfigE(cfg)
+	assert.Contains(t, err.Error(), "sinks[0]: open ")
+	assert.Contains(t, err.Error(), "sinks[2]: open ")
+	l = NewFromConfig(cfg)
+	assert.Equal(t, 1, len(l.sinks))
+	assert.Equal(t, os.Stderr, l.sinks[0].w)
+}
+
+// blockingWriter blocks every write until release is called.
+type blockingWriter struct {
+	mu      sync.Mutex
+	data    []string
+	started chan struct{}
+	wait    chan struct{}
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{started: make(chan struct{}, 100), wait: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (n int, err error) {
+	w.started <- struct{}{}
+	<-w.wait
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.data = append(w.data, string(p))
+	return len(p), nil
+}
+
+func (w *blockingWriter) release() {
+	close(w.wait)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	buf := &lineWriter{}
+	aw := NewAsyncWriter(buf, 0, "")
+	assert.Equal(t, defaultQueueSize, len(aw.queue))
+	assert.Equal(t, BlockOnOverflow, aw.policy)
+
+	l := New(aw, "api", 0, INFO, DefaultSeparator)
+	for i := 0; i < 300; i++ {
+		l.Infof("line %d", i)
+	}
+	assert.Nil(t, aw.Flush())
+	assert.Equal(t, 300, strings.Count(strings.Join(buf.lines, ""), "\n"))
+	assert.True(t, strings.HasSuffix(strings.Join(buf.lines, ""), "line 299\n"))
+
+	assert.Nil(t, aw.Close())
+	assert.Nil(t, aw.Close())
+	_, err := aw.Write([]byte("late\n"))
+	assert.Equal(t, errAsyncWriterClosed, err)
+	assert.Equal(t, uint64(0), aw.Dropped())
+}
+
+func TestAsyncWriter_Overflow(t *testing.T) {
+	for policy, expected := range map[overflowPolicy][]string{
+		DropNewest: {"a", "bc"},
+		DropOldest: {"a", "cd"},
+	} {
+		w := newBlockingWriter()
+		aw := NewAsyncWriter(w, 2, policy)
+		_, _ = aw.Write([]byte("a"))
+		<-w.started
+		for _, s := range []string{"b", "c", "d"} {
+			_, _ = aw.Write([]byte(s))
+		}
+		assert.Equal(t, uint64(1), aw.Dropped())
+		w.release()
+		assert.Nil(t, aw.Close())
+		assert.Equal(t, expected, w.data, string(policy))
+	}
+}
+
+func TestAsyncWriter_Block(t *testing.T) {
+	w := newBlockingWriter()
+	aw := NewAsyncWriter(w, 1, BlockOnOverflow)
+	_, _ = aw.Write([]byte("a"))
+	<-w.started
+	_, _ = aw.Write([]byte("b"))
+
+	written := make(chan struct{})
+	go func() {
+		_, _ = aw.Write([]byte("c"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	w.release()
+	<-written
+	assert.Nil(t, aw.Close())
+	assert.Equal(t, "abc", strings.Join(w.data, ""))
+	assert.Equal(t, uint64(0), aw.Dropped())
+}
+
+func TestFatalFlushesAsyncWriters(t *testing.T) {
+	exitCode := 0
+	exit = func(i int) { exitCode = i }
+	buf := &lineWriter{}
+	sinkBuf := &lineWriter{}
+	l := New(NewAsyncWriter(buf, 0, ""), "api", 0, INFO, DefaultSeparator).
+		AddSink(NewAsyncWriter(sinkBuf, 0, ""), ERROR)
+
+	l.Fatal("fatal")
+	assert.Equal(t, 1, exitCode)
+	assert.Equal(t, []string{"(api) -- [FATAL] -- fatal\n"}, buf.lines)
+	assert.Equal(t, []string{"(api) -- [FATAL] -- fatal\n"}, sinkBuf.lines)
+}
+
+func TestNewFromConfig_Async(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	l, err := NewFromConfigE(Config{
+		Title:          "api",
+		Direction:      path,
+		Async:          true,
+		AsyncQueueSize: 16,
+		AsyncOverflow:  "DROP_OLDEST",
+		Sinks:          []SinkConfig{{Direction: "stderr", Level: ERROR}},
+	})
+	assert.Nil(t, err)
+	aw := l.w.(*AsyncWriter)
+	assert.True(t, l.ownsW)
+	assert.True(t, aw.closeW)
+	assert.Equal(t, 16, len(aw.queue))
+	assert.Equal(t, DropOldest, aw.policy)
+	assert.False(t, l.sinks[0].w.(*AsyncWriter).closeW)
+
+	l.Warning("queued")
+	l.flush()
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "(api) -- [WARNING] -- queued\n", string(content))
+	assert.Nil(t, aw.Close())
+	assert.NotNil(t, aw.w.(*os.File).Close(), "file should have been closed")
+
+	_, err = NewFromConfigE(Config{Title: "api", AsyncOverflow: "wait", AsyncQueueSize: -1})
+	assert.Contains(t, err.Error(), "overflow policy wait invalid")
+	assert.Contains(t, err.Error(), "async_queue_size must not be negative")
+}
+
+type syncCounter struct {
+	lineWriter
+	syncs int
+}
+
+func (s *syncCounter) Sync() error {
+	s.syncs++
+	return nil
+}
+
+func TestAsyncWriter_SyncOnlyOwned(t *testing.T) {
+	w := &syncCounter{}
+	aw := NewAsyncWriter(w, 0, "")
+	assert.Nil(t, aw.Sync())
+	assert.Equal(t, 0, w.syncs)
+	aw.closeW = true
+	assert.Nil(t, aw.Sync())
+	assert.Equal(t, 1, w.syncs)
+	assert.Nil(t, aw.Close())
+
+	l, err := NewFromConfigE(Config{
+		Title: "api",
+		Async: true,
+		Sinks: []SinkConfig{{Direction: "stderr"}, {Direction: "stdout"}},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, l.Sync())
+	assert.Nil(t, l.Close())
+}
+
+type closerMock struct {
+	lineWriter
+	synced, flushed, closed int
+}
+
+func (c *closerMock) Sync() error {
+	c.synced++
+	return nil
+}
+
+func (c *closerMock) Flush() error {
+	c.flushed++
+	return nil
+}
+
+func (c *closerMock) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestSyncAndClose(t *testing.T) {
+	owned, sinkOwned, notOwned := &closerMock{}, &closerMock{}, &closerMock{}
+	l := New(owned, "api", 0, INFO, DefaultSeparator).AddSink(notOwned, INFO)
+	l.ownsW = true
+	l.sinks = append(l.sinks, &sink{w: sinkOwned, owned: true})
+
+	assert.Nil(t, l.Sync())
+	assert.Equal(t, 1, owned.synced)
+	assert.Equal(t, 1, sinkOwned.synced)
+	assert.Equal(t, 0, notOwned.synced)
+	assert.Equal(t, 1, notOwned.flushed)
+
+	assert.Nil(t, l.With("k", "v").Close())
+	assert.Equal(t, 1, owned.closed)
+	assert.Equal(t, 1, sinkOwned.closed)
+	assert.Equal(t, 0, notOwned.closed)
+	assert.Nil(t, l.w)
+	assert.Equal(t, 1, len(l.sinks))
+
+	l.Info("after close")
+	assert.Equal(t, []string{"(api) -- [INFO] -- after close\n"}, notOwned.lines)
+	assert.Nil(t, l.Close())
+}
+
+func TestShutdown(t *testing.T) {
+	registry.clear()
+	dir := t.TempDir()
+	for _, title := range []string{"api", "db"} {
+		_, err := AddLoggerFromConfig(Config{Title: title, Direction: filepath.Join(dir, title+".log"), Async: true})
+		assert.Nil(t, err)
+	}
+	api, _ := GetLogger("api")
+	api.Warning("buffered")
+
+	assert.Nil(t, Shutdown(context.Background()))
+	content, _ := os.ReadFile(filepath.Join(dir, "api.log"))
+	assert.Equal(t, "(api) -- [WARNING] -- buffered\n", string(content))
+	assert.Nil(t, api.w)
+
+	registry.clear()
+	w := newBlockingWriter()
+	slow := New(NewAsyncWriter(w, 1, BlockOnOverflow), "slow", 0, INFO, DefaultSeparator)
+	slow.ownsW = true
+	_ = AddLogger(slow)
+	slow.Info("stuck")
+	<-w.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	w.release()
+}
+
+func TestShutdownOnFatal(t *testing.T) {
+	registry.clear()
+	exit = func(i int) {}
+	defer SetFatalHook(nil)
+
+	path := filepath.Join(t.TempDir(), "api.log")
+	l, err := AddLoggerFromConfig(Config{Title: "api", Direction: path, Async: true})
+	assert.Nil(t, err)
+	ShutdownOnFatal(time.Second)
+
+	l.LogFatal(errors.New("some error"))
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "(api) -- [FATAL] -- some error\n", string(content))
+	assert.Nil(t, l.w)
+
+	hooked := 0
+	SetFatalHook(func() { hooked++ })
+	New(&lineWriter{}, "", 0, INFO, "").Fatalf("%d", 1)
+	assert.Equal(t, 1, hooked)
+}
+
+func TestSampling(t *testing.T) {
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	caller = runtime.Caller
+
+	w := &lineWriter{}
+	l := New(w, "api", 0, DEBUG, DefaultSeparator).SetSampling(2, 3, time.Minute)
+	for i := 0; i < 10; i++ {
+		l.Infof("hot %d", i)
+	}
+	l.Warning("other call site")
+	assert.Equal(t, []string{
+		"(api) -- [INFO] -- hot 0\n",
+		"(api) -- [INFO] -- hot 1\n",
+		"(api) -- [INFO] -- hot 4\n",
+		"(api) -- [INFO] -- hot 7\n",
+		"(api) -- [WARNING] -- other call site\n",
+	}, w.lines)
+
+	l.flush()
+	assert.Equal(t, "(api) -- [INFO] -- suppressed=6 -- suppressed 6 similar messages\n", w.lines[5])
+
+	clock = clock.Add(time.Minute)
+	l.Info("new tick")
+	assert.Equal(t, "(api) -- [INFO] -- new tick\n", w.lines[6])
+
+	l.SetSampling(0, 0, 0)
+	assert.Nil(t, l.limiter)
+}
+
+func TestSampling_ByMessage(t *testing.T) {
+	exit = func(i int) {}
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator)
+	l.limiter = newLimiter(SamplingConfig{First: 1, By: SampleByMessage, Tick: time.Hour}, RateLimitConfig{})
+	l.Error("a")
+	l.Error("a")
+	l.Error("b")
+	l.Fatalf("a")
+	assert.Equal(t, []string{"[ERROR] -- a\n", "[ERROR] -- b\n", "[FATAL] -- a\n", "[ERROR] -- suppressed=1 -- suppressed 1 similar messages\n"}, w.lines[:4])
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator).SetRateLimit(2, 3)
+	for i := 0; i < 5; i++ {
+		l.Infof("%d", i)
+	}
+	assert.Equal(t, 3, len(w.lines))
+
+	clock = clock.Add(time.Second)
+	for i := 5; i < 10; i++ {
+		l.Infof("%d", i)
+	}
+	assert.Equal(t, []string{"[INFO] -- 0\n", "[INFO] -- 1\n", "[INFO] -- 2\n", "[INFO] -- 5\n", "[INFO] -- 6\n"}, w.lines)
+
+	assert.Nil(t, l.Sync())
+	assert.Equal(t, "[INFO] -- suppressed=5 -- suppressed 5 similar messages\n", w.lines[5])
+	l.SetRateLimit(0, 0)
+	assert.Nil(t, l.limiter)
+}
+
+func TestSamplingSummaryTimer(t *testing.T) {
+	w := &lineWriter{}
+	l := New(&closerMock{}, "", 0, DEBUG, DefaultSeparator)
+	l.SetWriter(w).SetSampling(1, 0, 10*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		l.Warning("same call site")
+	}
+	assert.Eventually(t, func() bool {
+		l.writeMu.Lock()
+		defer l.writeMu.Unlock()
+		return len(w.lines) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "[WARNING] -- suppressed=1 -- suppressed 1 similar messages\n", w.lines[1])
+}
+
+func TestNewFromConfig_Sampling(t *testing.T) {
+	registry.clear()
+	path := writeConfigFile(t, "logging.yaml", `
+loggers:
+  - title: api
+    sampling:
+      first: 10
+      thereafter: 100
+      tick: 2s
+      by: message
+    rate_limit:
+      per_second: 50
+      burst: 5
+`)
+	assert.Nil(t, LoadConfigFile(path))
+	api, _ := GetLogger("api")
+	assert.Equal(t, SamplingConfig{First: 10, Thereafter: 100, Tick: 2 * time.Second, By: SampleByMessage}, api.limiter.sampling)
+	assert.Equal(t, RateLimitConfig{PerSecond: 50, Burst: 5}, api.limiter.rate)
+
+	changed, err := api.applyConfig(Config{Title: "api"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sampling"}, changed)
+	assert.Nil(t, api.limiter)
+
+	_, err = NewFromConfigE(Config{
+		Title:     "api",
+		Sampling:  SamplingConfig{First: -1, By: "level"},
+		RateLimit: RateLimitConfig{PerSecond: -1},
+	})
+	assert.Contains(t, err.Error(), "sampling first, thereafter and tick must not be negative")
+	assert.Contains(t, err.Error(), "sampling by level invalid")
+	assert.Contains(t, err.Error(), "rate_limit per_second and burst must not be negative")
+}
+
+func TestDedup(t *testing.T) {
+	caller = runtime.Caller
+	w := &lineWriter{}
+	l := New(w, "", Dedup, DEBUG, DefaultSeparator)
+	for i := 0; i < 4; i++ {
+		l.Info("same")
+	}
+	l.Info("other")
+	for i := 0; i < 2; i++ {
+		l.Info("same")
+	}
+	assert.Equal(t, []string{
+		"[INFO] -- same\n",
+		"[INFO] -- repeated=3 -- last message repeated 3 times\n",
+		"[INFO] -- other\n",
+		"[INFO] -- same\n",
+	}, w.lines)
+
+	assert.Nil(t, l.Sync())
+	assert.Equal(t, "[INFO] -- repeated=1 -- last message repeated 1 times\n", w.lines[4])
+
+	l.Info("same")
+	assert.Equal(t, 6, len(w.lines))
+}
+
+func TestDedup_DifferentCallersAndFields(t *testing.T) {
+	caller = runtime.Caller
+	w := &lineWriter{}
+	l := New(w, "", Dedup, DEBUG, DefaultSeparator)
+	l.Info("same")
+	l.Info("same")
+	for i := 0; i < 2; i++ {
+		l.InfoKV("kv", "i", i)
+	}
+	assert.Equal(t, 4, len(w.lines))
+
+	w.lines = nil
+	l.UnsetFlags(Dedup)
+	for i := 0; i < 2; i++ {
+		l.Info("same")
+	}
+	assert.Equal(t, 2, len(w.lines))
+}
+
+func TestDedup_Timeout(t *testing.T) {
+	w := &lineWriter{}
+	l := New(&closerMock{}, "", Dedup, DEBUG, DefaultSeparator)
+	l.SetWriter(w).SetDedupTimeout(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		l.Warning("same")
+	}
+	assert.Eventually(t, func() bool {
+		l.writeMu.Lock()
+		defer l.writeMu.Unlock()
+		return len(w.lines) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "[WARNING] -- repeated=2 -- last message repeated 2 times\n", w.lines[1])
+
+	l.Warning("same")
+	assert.Equal(t, 3, len(w.lines))
+}
+
+func TestNewFromConfig_Dedup(t *testing.T) {
+	l := NewFromConfig(Config{Title: "api", EnableDedup: true})
+	assert.Equal(t, Dedup, l.flag&Dedup)
+
+	changed, err := l.applyConfig(Config{Title: "api"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"flags"}, changed)
+	assert.Equal(t, 0, l.flag&Dedup)
+}
+
+func TestSetLevelForLogger_Hierarchy(t *testing.T) {
+	registry.clear()
+	billing := New(&lineWriter{}, "billing", 0, WARNING, DefaultSeparator)
+	invoice := New(&lineWriter{}, "billing.invoice", 0, WARNING, DefaultSeparator)
+	pdf := New(&lineWriter{}, "billing.invoice.pdf", 0, ERROR, DefaultSeparator)
+	other := New(&lineWriter{}, "billingx", 0, WARNING, DefaultSeparator)
+	for _, l := range []*Logger{billing, invoice, pdf, other} {
+		assert.Nil(t, AddLogger(l))
+	}
+
+	assert.Nil(t, SetLevelForLogger("billing.invoice", "info"))
+	assert.Nil(t, SetLevelForLogger("billing", "debug"))
+	assert.Equal(t, DEBUG, billing.level.load())
+	assert.Equal(t, INFO, invoice.level.load())
+	assert.Equal(t, INFO, pdf.level.load())
+	assert.Equal(t, WARNING, other.level.load())
+
+	late := New(&lineWriter{}, "billing.invoice.csv", 0, ERROR, DefaultSeparator)
+	assert.Nil(t, AddLogger(late))
+	assert.Equal(t, INFO, late.level.load())
+
+	assert.Nil(t, ResetLevel("billing.invoice"))
+	assert.Equal(t, DEBUG, invoice.level.load())
+	assert.Equal(t, DEBUG, pdf.level.load())
+
+	assert.Nil(t, ResetLevel("billing"))
+	assert.Equal(t, WARNING, invoice.level.load())
+	assert.Equal(t, ERROR, pdf.level.load())
+
+	registry.clear()
+	assert.Nil(t, AddLogger(pdf))
+	assert.Nil(t, SetLevelForLogger("billing", "debug"))
+	assert.Equal(t, DEBUG, pdf.level.load())
+	ResetLevels()
+	assert.Equal(t, ERROR, pdf.level.load())
+	err := SetLevelForLogger("bill", "debug")
+	assert.Equal(t, "logger with name bill does not exists", err.Error())
+}
+
+func TestGetOrCreateLogger(t *testing.T) {
+	registry.clear()
+	w := &lineWriter{}
+	billing := New(w, "billing", Labels, INFO, "|").SetFormat(LogfmtFormat)
+	assert.Nil(t, AddLogger(billing))
+
+	pdf, err := GetOrCreateLogger("billing.invoice.pdf")
+	assert.Nil(t, err)
+	assert.Equal(t, "billing.invoice.pdf", pdf.title)
+	assert.Equal(t, Labels, pdf.flag)
+	assert.Equal(t, "|", pdf.separator)
+	assert.Equal(t, INFO, pdf.level.load())
+	assert.False(t, pdf.ownsW)
+	pdf.Info("rendered")
+	assert.Equal(t, []string{"level=INFO title=billing.invoice.pdf msg=rendered\n"}, w.lines)
+
+	again, err := GetOrCreateLogger("billing.invoice.pdf")
+	assert.Nil(t, err)
+	assert.Same(t, pdf, again)
+	registered, _ := GetLogger("billing.invoice.pdf")
+	assert.Same(t, pdf, registered)
+
+	assert.Nil(t, SetLevelForLogger("billing", "error"))
+	assert.Equal(t, ERROR, pdf.level.load())
+	assert.Nil(t, ResetLevel("billing"))
+	assert.Equal(t, INFO, pdf.level.load())
+
+	_, err = GetOrCreateLogger("shipping.label")
+	assert.Equal(t, "logger with name shipping.label does not exists", err.Error())
+}
+
+func TestAdminHandler_Hierarchy(t *testing.T) {
+	registry.clear()
+	pdf := New(&lineWriter{}, "billing.invoice.pdf", 0, ERROR, DefaultSeparator)
+	assert.Nil(t, AddLogger(pdf))
+
+	code, _ := adminRequest(t, http.MethodPut, `{"logger": "billing", "level": "debug"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, DEBUG, pdf.level.load())
+
+	code, _ = adminRequest(t, http.MethodPut, `{"logger": "shipping", "level": "debug"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestSetLevelForLogger_Pattern(t *testing.T) {
+	registry.clear()
+	pool := New(&lineWriter{}, "db.pool", 0, WARNING, DefaultSeparator)
+	apiHTTP := New(&lineWriter{}, "api.http", 0, WARNING, DefaultSeparator)
+	assert.Nil(t, AddLogger(pool))
+	assert.Nil(t, AddLogger(apiHTTP))
+
+	assert.Nil(t, SetLevelForLogger("db.*", "debug"))
+	assert.Nil(t, SetLevelForLogger("*.http", "error"))
+	assert.Equal(t, DEBUG, pool.level.load())
+	assert.Equal(t, ERROR, apiHTTP.level.load())
+
+	conn := New(&lineWriter{}, "db.conn", 0, WARNING, DefaultSeparator)
+	assert.Nil(t, AddLogger(conn))
+	assert.Equal(t, DEBUG, conn.level.load())
+	adminHTTP, err := AddLoggerFromConfig(Config{Title: "admin.http"})
+	assert.Nil(t, err)
+	assert.Equal(t, ERROR, adminHTTP.level.load())
+
+	assert.Nil(t, SetLevelForLogger("db", "info"))
+	assert.Equal(t, INFO, pool.level.load())
+	assert.Nil(t, SetLevelForLogger("db.*", "debug"))
+	assert.Equal(t, DEBUG, pool.level.load())
+
+	assert.Equal(t, []LevelRule{
+		{Pattern: "*.http", Level: ERROR, Loggers: []string{"admin.http", "api.http"}},
+		{Pattern: "db", Level: INFO, Loggers: []string{"db.conn", "db.pool"}},
+		{Pattern: "db.*", Level: DEBUG, Loggers: []string{"db.conn", "db.pool"}},
+	}, LevelRules())
+
+	assert.Nil(t, ResetLevel("db.*"))
+	assert.Equal(t, INFO, pool.level.load())
+	err = ResetLevel("db.*")
+	assert.Equal(t, "level rule db.* does not exists", err.Error())
+
+	ResetLevels()
+	assert.Equal(t, []LevelRule{}, LevelRules())
+	assert.Equal(t, WARNING, apiHTTP.level.load())
+	late := New(&lineWriter{}, "web.http", 0, WARNING, DefaultSeparator)
+	assert.Nil(t, AddLogger(late))
+	assert.Equal(t, WARNING, late.level.load())
+
+	assert.Nil(t, SetLevelForLogger("queue.*", "debug"))
+	assert.Equal(t, []LevelRule{{Pattern: "queue.*", Level: DEBUG, Loggers: []string{}}}, LevelRules())
+	err = SetLevelForLogger("db.[", "debug")
+	assert.Equal(t, "pattern db.[ invalid", err.Error())
+}
+
+func TestAdminHandler_Pattern(t *testing.T) {
+	registry.clear()
+	code, _ := adminRequest(t, http.MethodPut, `{"logger": "*.http", "level": "debug"}`)
+	assert.Equal(t, http.StatusOK, code)
+	l := New(&lineWriter{}, "api.http", 0, WARNING, DefaultSeparator)
+	assert.Nil(t, AddLogger(l))
+	assert.Equal(t, DEBUG, l.level.load())
+}
+
+func traceInner() error {
+	return Trace(errors.New("inner"))
+}
+
+func traceOuter() error {
+	err := traceInner()
+	return Trace(err)
+}
+
+func TestTrace_FullStack(t *testing.T) {
+	defer SetTraceOptions(TraceOptions{})
+	SetTraceOptions(TraceOptions{FullStack: true, Filter: SkipFrames("runtime.", "testing.")})
+
+	frames := traceOuter().(TraceableError).GetAllStackFrames()
+	var functions []string
+	for _, frame := range frames {
+		functions = append(functions, strings.SplitN(strings.TrimPrefix(frame, "\t"), "\n", 2)[0])
+	}
+	assert.Equal(t, []string{
+		"github.com/Alliera/logging.traceOuter",
+		"github.com/Alliera/logging.TestTrace_FullStack",
+		"github.com/Alliera/logging.traceInner",
+		"github.com/Alliera/logging.traceOuter",
+		"error occurred: inner",
+	}, functions)
+	assert.Contains(t, frames[0], "logging_test.go:")
+	assert.NotEqual(t, frames[0], frames[3])
+
+	SetTraceOptions(TraceOptions{FullStack: true, MaxDepth: 1})
+	frames = traceInner().(TraceableError).GetAllStackFrames()
+	assert.Equal(t, 2, len(frames))
+	assert.Contains(t, frames[0], "logging.traceInner\n")
+
+	SetTraceOptions(TraceOptions{FullStack: true, Filter: func(runtime.Frame) bool { return false }})
+	frames = traceInner().(TraceableError).GetAllStackFrames()
+	assert.Equal(t, []string{"\terror occurred: inner"}, frames)
+}
+
+func TestGetAllStackFrames_CommonTail(t *testing.T) {
+	err := &traceableError{
+		stack: []string{"f2@trace", "f1", "main"},
+		err:   &traceableError{stack: []string{"f3", "f2@call", "f1", "main"}, err: errors.New("e")},
+	}
+	assert.Equal(t, []string{"f2@trace", "f1", "main", "f3", "f2@call", "\terror occurred: e"}, err.GetAllStackFrames())
+	assert.Equal(t, "f2@trace\nf1\nmain\nf3\nf2@call\n\terror occurred: e", err.GetTrace())
+
+	// frames repeated within one stack, or recorded at one frame each, are kept
+	err = &traceableError{
+		frame: "main",
+		err:   &traceableError{stack: []string{"recur", "recur", "main"}, err: errors.New("e")},
+	}
+	assert.Equal(t, []string{"main", "recur", "recur", "main", "\terror occurred: e"}, err.GetAllStackFrames())
+
+	// the frame where Trace was called is kept even when the whole stack is shared
+	err = &traceableError{
+		stack: []string{"f1", "main"},
+		err:   &traceableError{stack: []string{"f1", "main"}, err: errors.New("e")},
+	}
+	assert.Equal(t, []string{"f1", "main", "f1", "\terror occurred: e"}, err.GetAllStackFrames())
+}
+
+func traceRecursive(n int) error {
+	if n == 0 {
+		return errors.New("bottom")
+	}
+	return Trace(traceRecursive(n - 1))
+}
+
+func TestTrace_Recursive(t *testing.T) {
+	caller = runtime.Caller
+	defer SetTraceOptions(TraceOptions{})
+
+	frames := traceRecursive(3).(TraceableError).GetAllStackFrames()
+	assert.Equal(t, 4, len(frames))
+	for _, frame := range frames[:3] {
+		assert.Contains(t, frame, "logging.traceRecursive\n")
+	}
+
+	SetTraceOptions(TraceOptions{FullStack: true, Filter: SkipFrames("runtime.", "testing.")})
+	frames = traceRecursive(3).(TraceableError).GetAllStackFrames()
+	var recursive int
+	for _, frame := range frames {
+		if strings.Contains(frame, "logging.traceRecursive\n") {
+			recursive++
+		}
+	}
+	// one frame per level of recursion, the test function once
+	assert.Equal(t, 3, recursive)
+	assert.Equal(t, 5, len(frames))
+	assert.Contains(t, frames[1], "logging.TestTrace_Recursive\n")
+}
+
+type invoiceError struct{ id int }
+
+func (e *invoiceError) Error() string {
+	return fmt.Sprintf("invoice %d not found", e.id)
+}
+
+func TestTraceMsgAndTraceWith(t *testing.T) {
+	assert.Nil(t, TraceMsg(nil, "loading invoice"))
+	assert.Nil(t, TraceWith(nil, "invoice", 7))
+
+	base := &invoiceError{id: 7}
+	err := TraceWith(TraceMsg(base, "loading invoice"), "invoice", 7, "attempt", 2)
+	assert.Equal(t, "loading invoice: invoice 7 not found", err.Error())
+	assert.True(t, errors.Is(err, base))
+	var target *invoiceError
+	assert.True(t, errors.As(err, &target))
+	assert.Equal(t, 7, target.id)
+
+	frames := err.(TraceableError).GetAllStackFrames()
+	assert.Equal(t, 3, len(frames))
+	assert.True(t, strings.HasSuffix(frames[0], "\n\t\tinvoice=7 attempt=2"))
+	assert.True(t, strings.HasSuffix(frames[1], "\n\t\tloading invoice"))
+	assert.Equal(t, "\terror occurred: invoice 7 not found", frames[2])
+	assert.Equal(t, strings.Join(frames, "\n"), err.(TraceableError).GetTrace())
+}
+
+func TestLogError_TraceWithFields(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator).SetFormat(JSONFormat)
+	err := &traceableError{
+		frame:  "outer frame",
+		fields: []field{{key: "invoice", value: 7}},
+		err: fmt.Errorf("wrapped: %w", &traceableError{
+			frame:  "inner frame",
+			msg:    "loading invoice",
+			fields: []field{{key: "attempt", value: 2}},
+			err:    errors.New("not found"),
+		}),
+	}
+	l.LogError(err)
+	assert.Equal(t, 1, len(w.lines))
+	assert.Contains(t, w.lines[0], `"invoice":7,"attempt":2`)
+	assert.Contains(t, w.lines[0], `"msg":"wrapped: loading invoice: not found\nouter frame\n\t\tinvoice=7\n\terror occurred: wrapped: loading invoice: not found"`)
+
+	w.lines = nil
+	l.SetFormat(TextFormat).LogErrorf(err, "id %d", 7)
+	assert.True(t, strings.HasPrefix(w.lines[0], "[ERROR] -- invoice=7 -- attempt=2 -- wrapped: loading invoice: not found -- id 7\n"))
+}
+
+func TestGetAllStackFrames_JoinedErrors(t *testing.T) {
+	err := &traceableError{
+		stack: []string{"\touter\n\t\touter.go:1"},
+		err: errors.Join(
+			&traceableError{stack: []string{"\tinner\n\t\tinner.go:2", "\touter\n\t\touter.go:1"}, err: errors.New("a")},
+			errors.Join(errors.New("b"), nil, errors.New("c")),
+		),
+	}
+	assert.Equal(t, []string{
+		"\touter\n\t\touter.go:1",
+		"\t2 errors occurred:",
+		"\tbranch 1 of 2:",
+		"\t\tinner\n\t\t\tinner.go:2",
+		"\t\terror occurred: a",
+		"\tbranch 2 of 2:",
+		"\t\t2 errors occurred:",
+		"\t\tbranch 1 of 2:",
+		"\t\t\terror occurred: b",
+		"\t\tbranch 2 of 2:",
+		"\t\t\terror occurred: c",
+	}, err.GetAllStackFrames())
+}
+
+func TestLogError_JoinedErrors(t *testing.T) {
+	err := Trace(errors.Join(
+		errors.New("a"),
+		fmt.Errorf("wrapped: %w", errors.Join(errors.New("b"), errors.New("c"))),
+	))
+
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator).SetFormat(JSONFormat)
+	l.LogError(err)
+	assert.True(t, strings.HasSuffix(w.lines[0], `,"errors":["a",{"msg":"wrapped","errors":["b","c"]}]}`+"\n"))
+
+	w.lines = nil
+	l.SetFormat(LogfmtFormat).LogError(err)
+	assert.Contains(t, w.lines[0], ` errors="[a; wrapped: [b; c]]"`)
+
+	// the message of a traced branch is kept in every format
+	err = errors.Join(errors.New("a"), TraceMsg(errors.Join(errors.New("b"), errors.New("c")), "ctx"))
+	w.lines = nil
+	l.SetFormat(JSONFormat).LogError(err)
+	assert.True(t, strings.HasSuffix(w.lines[0], `,"errors":["a",{"msg":"ctx","errors":["b","c"]}]}`+"\n"))
+	w.lines = nil
+	l.SetFormat(TextFormat).LogError(err)
+	assert.True(t, strings.HasPrefix(w.lines[0], "[ERROR] -- errors=[a; ctx: [b; c]] -- "))
+	w.lines = nil
+	l.SetFormat(JSONFormat).LogError(Trace(errors.Join(errors.New("b"), errors.New("c"))))
+	assert.True(t, strings.HasSuffix(w.lines[0], `,"errors":["b","c"]}`+"\n"))
+
+	w.lines = nil
+	l.SetFormat(TextFormat).LogError(errors.Join(errors.New("a"), errors.New("b")))
+	assert.Equal(t, "[ERROR] -- errors=[a; b] -- a\nb\n", w.lines[0])
+}
+
+func panicky(v interface{}) {
+	panic(v)
+}
+
+func TestRecover(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "", ShortCaller, DEBUG, DefaultSeparator)
+	var recovered error
+	func() {
+		defer l.Recover(RecoverOptions{OnPanic: func(err error) { recovered = err }})
+		panicky("boom")
+	}()
+
+	assert.Equal(t, "panic: boom", recovered.Error())
+	frames := recovered.(TraceableError).GetAllStackFrames()
+	assert.Contains(t, frames[0], "logging.panicky\n")
+	for _, frame := range frames {
+		assert.NotContains(t, frame, "\truntime.")
+	}
+	assert.Equal(t, 1, len(w.lines))
+	assert.True(t, strings.HasPrefix(w.lines[0], "[ERROR] -- logging_test.go:"))
+	assert.Contains(t, w.lines[0], "-- panic: boom\n\tgithub.com/Alliera/logging.panicky\n")
+
+	base := errors.New("base")
+	assert.PanicsWithError(t, "base", func() {
+		defer l.Recover(RecoverOptions{Repanic: true, OnPanic: func(err error) { recovered = err }})
+		panicky(base)
+	})
+	assert.True(t, errors.Is(recovered, base))
+
+	l.Recover()
+	assert.Equal(t, 2, len(w.lines))
+}
+
+func TestRecover_Fatal(t *testing.T) {
+	exitCode := 0
+	exit = func(i int) { exitCode = i }
+	defer func() { exit = os.Exit }()
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator)
+	func() {
+		defer l.Recover(RecoverOptions{Level: FATAL})
+		panicky("boom")
+	}()
+	assert.Equal(t, 1, exitCode)
+	assert.True(t, strings.HasPrefix(w.lines[0], "[FATAL] -- panic: boom\n"))
+}
+
+func TestGo(t *testing.T) {
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator)
+	done := make(chan error)
+	Go(l, func() { panicky("in goroutine") }, RecoverOptions{OnPanic: func(err error) { done <- err }})
+	err := <-done
+	assert.Equal(t, "panic: in goroutine", err.Error())
+	assert.True(t, strings.HasPrefix(w.lines[0], "[ERROR] -- panic: in goroutine\n"))
+}
+
+func TestRegisterLevel(t *testing.T) {
+	defer levels.Store(getLevelTable())
+
+	trace, err := RegisterLevel("trace", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, level("TRACE"), trace)
+	notice, _ := RegisterLevel("Notice", 25)
+	critical, _ := RegisterLevel("CRITICAL", 45)
+
+	_, err = RegisterLevel("error", 35)
+	assert.Equal(t, "level ERROR is predefined", err.Error())
+	_, err = RegisterLevel("verbose", 5)
+	assert.Equal(t, "severity 5 of level VERBOSE already used by level TRACE", err.Error())
+	_, err = RegisterLevel("", 1)
+	assert.Equal(t, "level name is required", err.Error())
+	_, err = RegisterLevel("zero", 0)
+	assert.Equal(t, "severity 0 of level ZERO must be positive", err.Error())
+	_, err = RegisterLevel("negative", -5)
+	assert.Equal(t, "severity -5 of level NEGATIVE must be positive", err.Error())
+
+	lvl, err := levelFromString("notice")
+	assert.Nil(t, err)
+	assert.Equal(t, notice, lvl)
+
+	w := &lineWriter{}
+	l := New(w, "", 0, notice, DefaultSeparator)
+	assert.Equal(t, 1, l.GetLevelInt())
+	assert.Equal(t, 25, l.GetSeverity())
+	l.Log(trace, "hidden")
+	l.Info("hidden")
+	l.Log(notice, "shown")
+	l.Warning("shown")
+	l.Log(critical, "shown")
+	assert.Equal(t, []string{"[NOTICE] -- shown\n", "[WARNING] -- shown\n", "[CRITICAL] -- shown\n"}, w.lines)
+
+	registry.clear()
+	assert.Nil(t, AddLogger(l))
+	assert.Nil(t, SetLevelForAll("trace"))
+	assert.Equal(t, -1, l.GetLevelInt())
+	assert.True(t, l.isLevelHigherThanDefault(DEBUG))
+
+	cfgLogger, err := NewFromConfigE(Config{Title: "api", Level: "critical"})
+	assert.Nil(t, err)
+	assert.Equal(t, critical, cfgLogger.level.load())
+	assert.Equal(t, 3, cfgLogger.GetLevelInt())
+
+	for i, lvl := range []level{DEBUG, INFO, WARNING, ERROR, FATAL} {
+		assert.Equal(t, i, New(nil, "", 0, lvl, "").GetLevelInt())
+	}
+	assert.Equal(t, 0, (&Logger{}).GetLevelInt())
+	emerg, _ := RegisterLevel("emerg", 70)
+	assert.Equal(t, 4, New(nil, "", 0, emerg, "").GetLevelInt())
+
+	assert.Equal(t, slog.Level(-6), levelToSlog(trace))
+	assert.Equal(t, slog.Level(2), levelToSlog(notice))
+	assert.Equal(t, critical, levelFromSlog(slog.LevelError+2))
+	assert.Equal(t, trace, levelFromSlog(slog.LevelDebug-4))
+}
+
+func TestLog(t *testing.T) {
+	exitCode := 0
+	exit = func(i int) { exitCode = i }
+	defer func() { exit = os.Exit }()
+	w := &lineWriter{}
+	l := New(w, "", 0, DEBUG, DefaultSeparator)
+	l.Log(INFO, "info")
+	assert.Equal(t, 0, exitCode)
+	l.Log(FATAL, "fatal")
+	assert.Equal(t, 1, exitCode)
+	assert.Equal(t, []string{"[INFO] -- info\n", "[FATAL] -- fatal\n"}, w.lines)
+}
+
+func TestGetOrCreateLogger_FollowsAncestorWriters(t *testing.T) {
+	registry.clear()
+	sinkBuf := &lineWriter{}
+	billing := New(nil, "billing", 0, INFO, DefaultSeparator).SetRateLimit(10, 5).SetDedupTimeout(time.Minute)
+	billing.AddSink(sinkBuf, INFO)
+	assert.Nil(t, AddLogger(billing))
+
+	pdf, err := GetOrCreateLogger("billing.invoice.pdf")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitConfig{PerSecond: 10, Burst: 5}, pdf.limiter.rate)
+	assert.NotSame(t, billing.limiter, pdf.limiter)
+	assert.Equal(t, time.Minute, pdf.dedup.timeout)
+	pdf.Info("to the sink")
+	assert.Equal(t, []string{"(billing.invoice.pdf) -- [INFO] -- to the sink\n"}, sinkBuf.lines)
+
+	// the ancestor's writer changes after the descendant was created
+	w := &lineWriter{}
+	_, err = billing.applyConfig(Config{Title: "billing", Level: INFO, Direction: "stdout"})
+	assert.Nil(t, err)
+	billing.SetWriter(w)
+	pdf.Error("to the new writer")
+	assert.Equal(t, []string{"(billing.invoice.pdf) -- [ERROR] -- to the new writer\n"}, w.lines)
+
+	// nested descendants write through the whole chain
+	page, err := GetOrCreateLogger("billing.invoice.pdf.page")
+	assert.Nil(t, err)
+	page.Warning("nested")
+	assert.Equal(t, "(billing.invoice.pdf.page) -- [WARNING] -- nested\n", w.lines[1])
+
+	// a writer of its own detaches the descendant
+	own := &lineWriter{}
+	pdf.SetWriter(own)
+	pdf.Info("own")
+	assert.Equal(t, []string{"(billing.invoice.pdf) -- [INFO] -- own\n"}, own.lines)
+	assert.Equal(t, 2, len(w.lines))
+}
+
+func TestGetOrCreateLogger_AncestorClosed(t *testing.T) {
+	registry.clear()
+	path := filepath.Join(t.TempDir(), "billing.log")
+	billing, err := AddLoggerFromConfig(Config{Title: "billing", Direction: path})
+	assert.Nil(t, err)
+	pdf, _ := GetOrCreateLogger("billing.pdf")
+	pdf.Error("before close")
+	assert.Nil(t, billing.Close())
+
+	w := &lineWriter{}
+	billing.SetWriter(w)
+	pdf.Error("after close")
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "(billing.pdf) -- [ERROR] -- before close\n", string(content))
+	assert.Equal(t, []string{"(billing.pdf) -- [ERROR] -- after close\n"}, w.lines)
+}
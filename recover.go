@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// RecoverOptions control what Recover does with a panic. Level is ERROR or
+// FATAL, ERROR when empty. OnPanic, when set, is called with the logged
+// error. With Repanic the panic goes on after being logged.
+type RecoverOptions struct {
+	Level   level
+	OnPanic func(err error)
+	Repanic bool
+}
+
+/*
+Recover stops a panic, logs it like LogError, or LogFatal with a FATAL level,
+and then applies opts. The logged error is a TraceableError with the stack
+of the panic. Recover must be deferred directly to recover anything.
+
+In example:
+
+	defer l.Recover()
+	defer l.Recover(logging.RecoverOptions{Level: logging.FATAL})
+*/
+func (l *Logger) Recover(opts ...RecoverOptions) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	var opt RecoverOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	err := panicError(r)
+	lvl := ERROR
+	if opt.Level == FATAL {
+		lvl = FATAL
+	}
+	if l.enabled(lvl) {
+		l.output(&record{
+			time:     time.Now(),
+			level:    lvl,
+			msg:      l.root().getMsgFromError(err, nil),
+			callInfo: panicCallInfo(),
+			fields:   fieldsFromKV(errorKV(err)),
+		})
+	}
+
+	if opt.OnPanic != nil {
+		opt.OnPanic(err)
+	}
+	if lvl == FATAL {
+		l.fatalExit()
+	}
+	if opt.Repanic {
+		panic(r)
+	}
+}
+
+// Go runs fn in a new goroutine that recovers and logs its panics with l,
+// see Logger.Recover.
+func Go(l *Logger, fn func(), opts ...RecoverOptions) {
+	go func() {
+		defer l.Recover(opts...)
+		fn()
+	}()
+}
+
+// panicError turns the value r recovered from a panic into a traced error
+// with the stack of the panic. Frames of the runtime are left out unless the
+// trace options set a filter.
+func panicError(r interface{}) error {
+	trErr := new(traceableError)
+	if err, ok := r.(error); ok {
+		trErr.err = fmt.Errorf("panic: %w", err)
+	} else {
+		trErr.err = fmt.Errorf("panic: %v", r)
+	}
+
+	opts := getTraceOptions()
+	if opts.Filter == nil {
+		opts.Filter = SkipFrames("runtime.")
+	}
+	// Skip panicError and Recover.
+	trErr.stack = trErr.captureStack(2, opts)
+	return trErr
+}
+
+// panicCallInfo returns the frame that panicked, the first one outside of
+// the runtime above Recover.
+func panicCallInfo() callInfo {
+	// Skip runtime.Callers, panicCallInfo and Recover.
+	pcs := make([]uintptr, maxTraceDepth)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			return callInfo{file: frame.File, line: frame.Line, pc: frame.PC}
+		}
+		if !more {
+			return callInfo{file: sourceErr, line: -1}
+		}
+	}
+}
@@ -2,6 +2,9 @@ package logging
 
 import (
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -9,20 +12,139 @@ var registry = newRegistry()
 
 func newRegistry() *loggerRegistry {
 	return &loggerRegistry{
-		loggers: make(map[string]*Logger),
+		loggers:  make(map[string]*Logger),
+		levels:   make(map[string]levelRule),
+		patterns: make(map[string]levelRule),
 	}
 }
 
+/*
+loggerRegistry holds the loggers by title. Titles are dot-separated paths,
+so "billing.invoice" is a descendant of "billing". A level set by name
+applies to the named logger and to its descendants, except those below a
+more specific name that has a level of its own. A level set by pattern, like
+"db.*", applies to every matching logger. When both apply to a logger, the
+one set last wins. Levels stay in force for loggers registered later.
+*/
 type loggerRegistry struct {
 	loggers map[string]*Logger
-	mu      sync.Mutex
+	// levels holds the levels set by name, with or without a logger of that
+	// name, and patterns the levels set by pattern.
+	levels   map[string]levelRule
+	patterns map[string]levelRule
+	// seq orders the rules by the time they were set.
+	seq int
+	mu  sync.Mutex
+}
+
+type levelRule struct {
+	level level
+	seq   int
+}
+
+// LevelRule is a level set with SetLevelForLogger for a name or a pattern,
+// with the titles of the registered loggers it matches.
+type LevelRule struct {
+	Pattern string   `json:"pattern"`
+	Level   level    `json:"level"`
+	Loggers []string `json:"loggers"`
 }
 
 func (r *loggerRegistry) clear() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.loggers = map[string]*Logger{}
+	r.clearRules()
+}
+
+// clearRules removes every level set by name or pattern. r.mu must be held.
+func (r *loggerRegistry) clearRules() {
+	r.levels = map[string]levelRule{}
+	r.patterns = map[string]levelRule{}
+}
+
+// isDescendant reports whether name is ancestor or below it.
+func isDescendant(name, ancestor string) bool {
+	return name == ancestor || strings.HasPrefix(name, ancestor+".")
+}
+
+// parentName returns the name one level up, or "" for top level names.
+func parentName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// isPattern reports whether name is a pattern in the syntax of path.Match.
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[\\")
+}
+
+// matches reports whether the logger title is matched by the rule for
+// name, which is a pattern or a name that covers its descendants.
+func matches(name, title string) bool {
+	if isPattern(name) {
+		ok, _ := path.Match(name, title)
+		return ok
+	}
+	return isDescendant(title, name)
+}
+
+// inheritedLevel returns the level set for name or its nearest ancestor, or
+// by a pattern matching name, whichever was set last. r.mu must be held.
+func (r *loggerRegistry) inheritedLevel(name string) (level, bool) {
+	var (
+		rule  levelRule
+		found bool
+	)
+	for n := name; n != ""; n = parentName(n) {
+		if rule, found = r.levels[n]; found {
+			break
+		}
+	}
+	for pattern, p := range r.patterns {
+		if matches(pattern, name) && (!found || p.seq > rule.seq) {
+			rule, found = p, true
+		}
+	}
+	return rule.level, found
+}
+
+// has reports whether a logger named name or one of its descendants is
+// registered.
+func (r *loggerRegistry) has(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hasTree(name)
+}
+
+// hasTree reports whether a logger named name or one of its descendants is
+// registered. r.mu must be held.
+func (r *loggerRegistry) hasTree(name string) bool {
+	for title := range r.loggers {
+		if isDescendant(title, name) {
+			return true
+		}
+	}
+	return false
 }
+
+// applyLevels sets the level of the loggers matched by name to the level
+// they inherit, or back to their original one. r.mu must be held.
+func (r *loggerRegistry) applyLevels(name string) {
+	for title, logger := range r.loggers {
+		if !matches(name, title) {
+			continue
+		}
+		if lvl, ok := r.inheritedLevel(title); ok {
+			logger.SetLevel(lvl)
+		} else {
+			logger.resetLevel()
+		}
+	}
+}
+
 func (r *loggerRegistry) addLogger(l *Logger) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -30,12 +152,19 @@ func (r *loggerRegistry) addLogger(l *Logger) error {
 		return fmt.Errorf("logger with name %s already exists", l.title)
 	}
 	r.loggers[l.title] = l
+	if lvl, ok := r.inheritedLevel(l.title); ok {
+		l.SetLevel(lvl)
+	}
 	return nil
 }
 
 func (r *loggerRegistry) addLoggerFromConfig(cfg Config) (*Logger, error) {
-	l := NewFromConfig(cfg)
+	l, err := NewFromConfigE(cfg)
+	if err != nil {
+		return nil, err
+	}
 	if err := r.addLogger(l); err != nil {
+		_ = l.Close()
 		return nil, err
 	}
 	return l, nil
@@ -50,31 +179,150 @@ func (r *loggerRegistry) getLogger(name string) (*Logger, error) {
 	return nil, fmt.Errorf("logger with name %s does not exists", name)
 }
 
+// getOrCreateLogger returns the logger named name. When there is none, it
+// creates and registers one that inherits the writer, flags, separator,
+// format and level of its nearest registered ancestor.
+func (r *loggerRegistry) getOrCreateLogger(name string) (*Logger, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.loggers[name]; ok {
+		return l, nil
+	}
+
+	for ancestor := parentName(name); ancestor != ""; ancestor = parentName(ancestor) {
+		if parent, ok := r.loggers[ancestor]; ok {
+			l := parent.descendant(name)
+			r.loggers[name] = l
+			if lvl, ok := r.inheritedLevel(name); ok {
+				l.SetLevel(lvl)
+			}
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("logger with name %s does not exists", name)
+}
+
 func (r *loggerRegistry) setLevelForLogger(name string, l level) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if logger, ok := r.loggers[name]; ok {
-		logger.level = l
-		return nil
+	r.seq++
+	rule := levelRule{level: l, seq: r.seq}
+	if isPattern(name) {
+		if _, err := path.Match(name, ""); err != nil {
+			return fmt.Errorf("pattern %s invalid", name)
+		}
+		r.patterns[name] = rule
+	} else if r.hasTree(name) {
+		r.levels[name] = rule
+	} else {
+		return fmt.Errorf("logger with name %s does not exists", name)
 	}
-	return fmt.Errorf("logger with name %s does not exists", name)
+	r.applyLevels(name)
+	return nil
 }
 
 func (r *loggerRegistry) setLevelForAll(l level) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.clearRules()
 	for _, logger := range r.loggers {
-		logger.level = l
+		logger.SetLevel(l)
 	}
 }
 
+func (r *loggerRegistry) resetLevel(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if isPattern(name) {
+		if _, ok := r.patterns[name]; !ok {
+			return fmt.Errorf("level rule %s does not exists", name)
+		}
+		delete(r.patterns, name)
+	} else if r.hasTree(name) {
+		delete(r.levels, name)
+	} else {
+		return fmt.Errorf("logger with name %s does not exists", name)
+	}
+	r.applyLevels(name)
+	return nil
+}
+
 func (r *loggerRegistry) resetLevels() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.clearRules()
 	for _, logger := range r.loggers {
 		logger.resetLevel()
 	}
 }
+
+// rules returns the levels set by name or pattern in the order they were
+// set, with the loggers each one matches.
+func (r *loggerRegistry) rules() []LevelRule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type entry struct {
+		name string
+		levelRule
+	}
+	var entries []entry
+	for name, rule := range r.levels {
+		entries = append(entries, entry{name, rule})
+	}
+	for pattern, rule := range r.patterns {
+		entries = append(entries, entry{pattern, rule})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+
+	rules := make([]LevelRule, 0, len(entries))
+	for _, e := range entries {
+		rule := LevelRule{Pattern: e.name, Level: e.level, Loggers: []string{}}
+		for title := range r.loggers {
+			if matches(e.name, title) {
+				rule.Loggers = append(rule.Loggers, title)
+			}
+		}
+		sort.Strings(rule.Loggers)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (r *loggerRegistry) all() []*Logger {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loggers := make([]*Logger, 0, len(r.loggers))
+	for _, l := range r.loggers {
+		loggers = append(loggers, l)
+	}
+	return loggers
+}
+
+// descendant returns a new logger named name with the flags, separator,
+// format, level, sampling and dedup timeout of l. It writes to the writer and
+// sinks l has at the time of each record.
+func (l *Logger) descendant(name string) *Logger {
+	c := l.root()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d := New(nil, name, c.flag, c.level.load(), c.separator)
+	d.originalLevel = c.originalLevel
+	d.enc = c.enc
+	d.writers = c
+	if c.limiter != nil {
+		d.limiter = newLimiter(c.limiter.sampling, c.limiter.rate)
+	}
+	c.dedup.mu.Lock()
+	d.dedup.timeout = c.dedup.timeout
+	c.dedup.mu.Unlock()
+	return d
+}
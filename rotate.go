@@ -0,0 +1,332 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	megabyte        = 1024 * 1024
+	day             = 24 * time.Hour
+	backupTimeFmt   = "2006-01-02T15-04-05.000"
+	compressSuffix  = ".gz"
+	defaultFileMode = 0666
+	// rotateRetryDelay is how long writes go to the active file before a
+	// failed rotation is tried again.
+	rotateRetryDelay = time.Minute
+)
+
+var now = time.Now
+
+/*
+RotatingFile is an io.WriteCloser that appends to a file and rotates it.
+
+The active file is rotated once the next write would take it beyond the max
+size, or once it has been written for longer than the max age. Rotated files
+are renamed to "<name>-<timestamp><ext>", optionally gzip-compressed, and
+removed when there are more than max backups of them or when they are older
+than the max age. A zero limit disables the corresponding check.
+
+The age of an existing file is counted from the newest backup, or from its
+modification time when there is none, so that restarts don't reset it.
+Backups are compressed and removed in the background, one rotation at a
+time, so writes never wait for them nor fail because of them. Writes go to
+the active file when it can't be rotated. Close waits for the background work.
+
+In example:
+
+	w, err := logging.NewRotatingFile("/var/log/api.log", 100, 7, 30, true)
+*/
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// retryAt is set when a rotation failed, no rotation is tried before.
+	retryAt time.Time
+
+	// cleanupMu serialises the cleanups of rotated files, cleanups tracks
+	// the ones running in the background.
+	cleanupMu sync.Mutex
+	cleanups  sync.WaitGroup
+}
+
+func NewRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * megabyte,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * day,
+		compress:   compress,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Name() string {
+	return rf.path
+}
+
+func (rf *RotatingFile) Write(p []byte) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		if err = rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.needsRotation(int64(len(p))) {
+		backup, rotateErr := rf.rotate()
+		if rotateErr == nil {
+			rf.cleanups.Add(1)
+			go func() {
+				defer rf.cleanups.Done()
+				// Best effort, the line is written anyway.
+				_ = rf.cleanup(backup)
+			}()
+		} else {
+			rf.retryAt = now().Add(rotateRetryDelay)
+		}
+		if rf.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err = rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the active file, turns it into a backup and opens a new one.
+// It waits for the backup to be compressed and for old ones to be removed,
+// and reports their errors, but doesn't hold up writes meanwhile.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	backup, err := rf.rotate()
+	rf.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return rf.cleanup(backup)
+}
+
+// Sync commits the active file to storage.
+func (rf *RotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Sync()
+}
+
+// Close closes the active file and waits for the backups to be cleaned up.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	err := rf.close()
+	rf.mu.Unlock()
+	rf.cleanups.Wait()
+	return err
+}
+
+func (rf *RotatingFile) needsRotation(next int64) bool {
+	if now().Before(rf.retryAt) {
+		return false
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+next > rf.maxSize {
+		return true
+	}
+	return rf.maxAge > 0 && now().Sub(rf.openedAt) >= rf.maxAge
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.openedAt = rf.createdAt(info)
+	return nil
+}
+
+// createdAt estimates when the active file was created: at the last
+// rotation, or when it was last modified if it was never rotated.
+func (rf *RotatingFile) createdAt(info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return now()
+	}
+	if backups, err := rf.backups(); err == nil && len(backups) > 0 {
+		return backups[0].time
+	}
+	return info.ModTime()
+}
+
+func (rf *RotatingFile) close() error {
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// rotate turns the active file into the backup it returns and opens a new
+// one. When the rename fails, the active file is opened again.
+func (rf *RotatingFile) rotate() (backup string, err error) {
+	if err := rf.close(); err != nil {
+		return "", err
+	}
+
+	backup = rf.backupName(now())
+	if err := os.Rename(rf.path, backup); err != nil && !os.IsNotExist(err) {
+		if openErr := rf.open(); openErr != nil {
+			return "", multiError{err, openErr}
+		}
+		return "", err
+	}
+	return backup, rf.open()
+}
+
+// cleanup compresses backup and removes the backups beyond the limits. It
+// doesn't need rf.mu, cleanups run one at a time.
+func (rf *RotatingFile) cleanup(backup string) error {
+	rf.cleanupMu.Lock()
+	defer rf.cleanupMu.Unlock()
+
+	var errs multiError
+	if rf.compress {
+		if err := compressFile(backup); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := rf.removeOldBackups(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.errorOrNil()
+}
+
+// backupName returns a free backup file name for the rotation time t.
+func (rf *RotatingFile) backupName(t time.Time) string {
+	dir := filepath.Dir(rf.path)
+	ext := filepath.Ext(rf.path)
+	prefix := strings.TrimSuffix(filepath.Base(rf.path), ext) + "-"
+	for {
+		name := filepath.Join(dir, prefix+t.Format(backupTimeFmt)+ext)
+		if !fileExists(name) && !fileExists(name+compressSuffix) {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// backups returns the rotated files of rf, newest first.
+func (rf *RotatingFile) backups() ([]backupFile, error) {
+	dir := filepath.Dir(rf.path)
+	ext := filepath.Ext(rf.path)
+	prefix := strings.TrimSuffix(filepath.Base(rf.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), compressSuffix)
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		t, err := time.ParseInLocation(backupTimeFmt, ts, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, e.Name()), time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+	return backups, nil
+}
+
+func (rf *RotatingFile) removeOldBackups() error {
+	if rf.maxBackups <= 0 && rf.maxAge <= 0 {
+		return nil
+	}
+	backups, err := rf.backups()
+	if err != nil {
+		return err
+	}
+
+	var errs multiError
+	cutoff := now().Add(-rf.maxAge)
+	for i, b := range backups {
+		tooMany := rf.maxBackups > 0 && i >= rf.maxBackups
+		tooOld := rf.maxAge > 0 && b.time.Before(cutoff)
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs.errorOrNil()
+}
+
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := os.OpenFile(path+compressSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + compressSuffix)
+		return fmt.Errorf("compress %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
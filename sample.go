@@ -0,0 +1,245 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SampleByCaller  = "caller"
+	SampleByMessage = "message"
+
+	defaultSampleTick = time.Second
+)
+
+// SamplingConfig keeps the First records of every call site (or message)
+// per Tick, and then every Thereafter-th one. A zero First disables
+// sampling, a zero Thereafter drops everything after the first ones.
+type SamplingConfig struct {
+	First      int           `yaml:"first"`
+	Thereafter int           `yaml:"thereafter"`
+	Tick       time.Duration `yaml:"tick"`
+	By         string        `yaml:"by"`
+}
+
+// RateLimitConfig limits a logger to PerSecond records per second with
+// bursts of up to Burst records. A zero PerSecond disables the limit.
+type RateLimitConfig struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst"`
+}
+
+func (sc SamplingConfig) enabled() bool {
+	return sc.First > 0
+}
+
+func (sc SamplingConfig) tick() time.Duration {
+	if sc.Tick <= 0 {
+		return defaultSampleTick
+	}
+	return sc.Tick
+}
+
+func (sc SamplingConfig) validate() (errs multiError) {
+	if sc.First < 0 || sc.Thereafter < 0 || sc.Tick < 0 {
+		errs = append(errs, errors.New("sampling first, thereafter and tick must not be negative"))
+	}
+	if sc.By != "" && sc.By != SampleByCaller && sc.By != SampleByMessage {
+		errs = append(errs, fmt.Errorf("sampling by %s invalid", sc.By))
+	}
+	return errs
+}
+
+func (rc RateLimitConfig) enabled() bool {
+	return rc.PerSecond > 0
+}
+
+func (rc RateLimitConfig) burst() float64 {
+	if rc.Burst < 1 {
+		return 1
+	}
+	return float64(rc.Burst)
+}
+
+func (rc RateLimitConfig) validate() (errs multiError) {
+	if rc.PerSecond < 0 || rc.Burst < 0 {
+		errs = append(errs, errors.New("rate_limit per_second and burst must not be negative"))
+	}
+	return errs
+}
+
+// limiter drops records according to the sampling and rate limit settings
+// of a Logger. Dropped records are counted and reported by a summary record
+// written once per sampling tick.
+type limiter struct {
+	sampling SamplingConfig
+	rate     RateLimitConfig
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[uint64]int
+	tokens float64
+	last   time.Time
+
+	suppressed int
+	maxLevel   level
+	timer      *time.Timer
+}
+
+func newLimiter(sampling SamplingConfig, rate RateLimitConfig) *limiter {
+	if !sampling.enabled() && !rate.enabled() {
+		return nil
+	}
+	return &limiter{
+		sampling: sampling,
+		rate:     rate,
+		counts:   make(map[uint64]int),
+		tokens:   rate.burst(),
+		last:     now(),
+	}
+}
+
+// SetSampling keeps the first records of every call site per tick and then
+// every thereafter-th one. A zero first disables sampling.
+func (l *Logger) SetSampling(first, thereafter int, tick time.Duration) *Logger {
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rate := RateLimitConfig{}
+	if c.limiter != nil {
+		rate = c.limiter.rate
+	}
+	c.limiter = newLimiter(SamplingConfig{First: first, Thereafter: thereafter, Tick: tick}, rate)
+	return l
+}
+
+// SetRateLimit limits l to perSecond records per second, with bursts of up
+// to burst records. A zero perSecond removes the limit.
+func (l *Logger) SetRateLimit(perSecond float64, burst int) *Logger {
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sampling := SamplingConfig{}
+	if c.limiter != nil {
+		sampling = c.limiter.sampling
+	}
+	c.limiter = newLimiter(sampling, RateLimitConfig{PerSecond: perSecond, Burst: burst})
+	return l
+}
+
+// allow reports whether r may be written. FATAL records are never dropped.
+func (lm *limiter) allow(l *Logger, r *record) bool {
+	if r.level == FATAL {
+		return true
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	t := now()
+	if lm.sampled(r, t) && lm.takeToken(t) {
+		return true
+	}
+
+	lm.suppressed++
+	if r.level.severity() > lm.maxLevel.severity() || lm.maxLevel == "" {
+		lm.maxLevel = r.level
+	}
+	if lm.timer == nil {
+		lm.timer = time.AfterFunc(lm.sampling.tick(), func() {
+			lm.summarize(l)
+		})
+	}
+	return false
+}
+
+func (lm *limiter) sampled(r *record, t time.Time) bool {
+	if !lm.sampling.enabled() {
+		return true
+	}
+	if t.Sub(lm.window) >= lm.sampling.tick() {
+		lm.window = t
+		lm.counts = make(map[uint64]int)
+	}
+
+	key := lm.key(r)
+	lm.counts[key]++
+	n := lm.counts[key]
+	if n <= lm.sampling.First {
+		return true
+	}
+	return lm.sampling.Thereafter > 0 && (n-lm.sampling.First)%lm.sampling.Thereafter == 0
+}
+
+func (lm *limiter) key(r *record) uint64 {
+	h := fnv.New64a()
+	if lm.sampling.By == SampleByMessage {
+		_, _ = h.Write([]byte(r.msg))
+	} else {
+		_, _ = h.Write([]byte(r.callInfo.file))
+		_, _ = h.Write([]byte(strconv.Itoa(r.callInfo.line)))
+	}
+	return h.Sum64()
+}
+
+// takeToken takes a token from the bucket of the rate limit, if there is one.
+func (lm *limiter) takeToken(t time.Time) bool {
+	if !lm.rate.enabled() {
+		return true
+	}
+	lm.tokens += t.Sub(lm.last).Seconds() * lm.rate.PerSecond
+	if lm.tokens > lm.rate.burst() {
+		lm.tokens = lm.rate.burst()
+	}
+	lm.last = t
+	if lm.tokens < 1 {
+		return false
+	}
+	lm.tokens--
+	return true
+}
+
+// summarize writes how many records were dropped since the last summary, at
+// the highest level among them.
+func (lm *limiter) summarize(l *Logger) {
+	lm.mu.Lock()
+	n, lvl := lm.suppressed, lm.maxLevel
+	lm.suppressed, lm.maxLevel, lm.timer = 0, "", nil
+	lm.mu.Unlock()
+
+	if n == 0 {
+		return
+	}
+	l.write(&record{
+		time:     now(),
+		level:    lvl,
+		msg:      fmt.Sprintf("suppressed %d similar messages", n),
+		callInfo: callInfo{file: sourceErr, line: -1},
+		fields:   []field{{key: "suppressed", value: n}},
+	})
+}
+
+// flush writes the pending summary right away.
+func (lm *limiter) flush(l *Logger) {
+	lm.mu.Lock()
+	if lm.timer != nil {
+		lm.timer.Stop()
+	}
+	lm.mu.Unlock()
+	lm.summarize(l)
+}
+
+// flushSummary writes the pending summaries of dropped and repeated records
+// of l.
+func (l *Logger) flushSummary() {
+	l.mu.RLock()
+	lm := l.limiter
+	l.mu.RUnlock()
+	if lm != nil {
+		lm.flush(l)
+	}
+	l.dedup.flush(l)
+}
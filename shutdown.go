@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// syncer is implemented by writers that can commit their data to storage,
+// like *os.File.
+type syncer interface {
+	Sync() error
+}
+
+var (
+	fatalHookMu sync.Mutex
+	fatalHook   func()
+)
+
+// Sync flushes every buffering writer of l and commits the writers it opened
+// to storage.
+func (l *Logger) Sync() error {
+	c := l.root()
+	c.flushSummary()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sync()
+}
+
+// Close syncs l and closes the writers it opened from its config. They are
+// removed from l, so records logged afterwards only reach the writers that
+// were passed in by the caller.
+func (l *Logger) Close() error {
+	c := l.root()
+	c.flushSummary()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := configErrors(c.sync())
+	if cl, ok := c.w.(io.Closer); ok && c.ownsW {
+		if err := cl.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		c.w = nil
+		c.ownsW = false
+	}
+	var kept []*sink
+	for _, s := range c.sinks {
+		if cl, ok := s.w.(io.Closer); ok && s.owned {
+			if err := cl.Close(); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		kept = append(kept, s)
+	}
+	c.sinks = kept
+	return errs.errorOrNil()
+}
+
+// sync flushes and syncs the writers of l. l.mu must be held.
+func (l *Logger) sync() error {
+	var errs multiError
+	syncWriter := func(w io.Writer, owned bool) {
+		var err error
+		if s, ok := w.(syncer); ok && owned {
+			err = s.Sync()
+		} else if f, ok := w.(flusher); ok {
+			err = f.Flush()
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if l.w != nil {
+		syncWriter(l.w, l.ownsW)
+	}
+	for _, s := range l.sinks {
+		syncWriter(s.w, s.owned)
+	}
+	return errs.errorOrNil()
+}
+
+// Shutdown closes every registered logger, see Logger.Close. It gives up
+// waiting when ctx is done and then returns its error along with the ones
+// reported so far.
+func Shutdown(ctx context.Context) error {
+	loggers := registry.all()
+	results := make(chan error, len(loggers))
+	for _, l := range loggers {
+		go func(l *Logger) {
+			results <- l.Close()
+		}(l)
+	}
+
+	var errs multiError
+	for range loggers {
+		select {
+		case err := <-results:
+			errs = append(errs, configErrors(err)...)
+		case <-ctx.Done():
+			return append(errs, ctx.Err())
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// SetFatalHook sets a function run by Fatal and the other fatal methods
+// after the record is written and before the process exits. A nil fn
+// removes the hook.
+func SetFatalHook(fn func()) {
+	fatalHookMu.Lock()
+	defer fatalHookMu.Unlock()
+	fatalHook = fn
+}
+
+// ShutdownOnFatal makes the fatal methods run Shutdown, waiting at most
+// timeout, before the process exits.
+func ShutdownOnFatal(timeout time.Duration) {
+	SetFatalHook(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = Shutdown(ctx)
+	})
+}
+
+// fatalExit flushes l, runs the fatal hook and exits.
+func (l *Logger) fatalExit() {
+	l.flush()
+	fatalHookMu.Lock()
+	hook := fatalHook
+	fatalHookMu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	exit(1)
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sink is an additional destination of a Logger. It receives the records
+// that pass the level of the Logger and its own level.
+type sink struct {
+	w     io.Writer
+	level level
+	// enc is nil when the sink uses the format of the Logger.
+	enc encoder
+	// owned is set when w was opened from a config and must be closed.
+	owned bool
+}
+
+func (s *sink) getEncoder(l *Logger) encoder {
+	if s.enc == nil {
+		return l.getEncoder()
+	}
+	return s.enc
+}
+
+// SinkConfig describes a sink in Config. Direction and the rotation settings
+// work as in Config, an empty Format means the format of the logger.
+type SinkConfig struct {
+	Direction  string `yaml:"direction"`
+	Level      level  `yaml:"level"`
+	Format     format `yaml:"format"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+	MaxAgeDays int    `yaml:"max_age_days"`
+	Compress   bool   `yaml:"compress"`
+}
+
+// AddSink makes l also write the records of at least lvl to w, in the format
+// f if given and in the format of l otherwise. Records below the level of l
+// are never written, whatever the level of the sink.
+//
+// In example, errors to stderr and everything to a debug file:
+//
+//	l := logging.New(nil, "api", logging.Time, logging.DEBUG, "|").
+//		AddSink(os.Stderr, logging.ERROR).
+//		AddSink(debugFile, logging.DEBUG, logging.JSONFormat)
+func (l *Logger) AddSink(w io.Writer, lvl level, f ...format) *Logger {
+	s := &sink{w: w, level: lvl}
+	if len(f) > 0 {
+		s.enc, _ = encoderFromFormat(f[0])
+	}
+
+	c := l.root()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sinks = append(c.sinks, s)
+	return l
+}
+
+// RemoveSinks removes every sink of l, closing the ones it opened.
+func (l *Logger) RemoveSinks() *Logger {
+	c := l.root()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	closeSinks(c.sinks)
+	c.sinks = nil
+	return l
+}
+
+// config returns sc as a Config, to share its validation and the opening of
+// its direction.
+func (sc SinkConfig) config() Config {
+	return Config{
+		Level:      sc.Level,
+		Format:     sc.Format,
+		Direction:  sc.Direction,
+		MaxSizeMB:  sc.MaxSizeMB,
+		MaxBackups: sc.MaxBackups,
+		MaxAgeDays: sc.MaxAgeDays,
+		Compress:   sc.Compress,
+	}
+}
+
+// openSinks opens the sinks of cfg that can be opened and returns an error for
+// each one that can't.
+func openSinks(cfg Config) (sinks []*sink, errs multiError) {
+	for i, sc := range cfg.Sinks {
+		w, owned, err := cfg.openWriter(sc.config())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sinks[%d]: %w", i, err))
+			continue
+		}
+		s := &sink{w: w, level: level(strings.ToUpper(string(sc.Level))), owned: owned}
+		if sc.Format != "" {
+			s.enc, _ = encoderFromFormat(sc.Format)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, errs
+}
+
+func closeSinks(sinks []*sink) {
+	for _, s := range sinks {
+		if c, ok := s.w.(io.Closer); ok && s.owned {
+			_ = c.Close()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SlogHandler is a slog.Handler that writes records through a Logger. Attrs
+// become fields, attrs inside groups are keyed "group.key".
+type SlogHandler struct {
+	l      *Logger
+	prefix string
+}
+
+// NewSlogHandler returns a slog.Handler writing through l.
+//
+// In example:
+//
+//	l, _ := logging.GetLogger("api")
+//	slog.SetDefault(slog.New(logging.NewSlogHandler(l)))
+func NewSlogHandler(l *Logger) *SlogHandler {
+	return &SlogHandler{l: l}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return h.l.enabled(levelFromSlog(lvl))
+}
+
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	var kv []interface{}
+	r.Attrs(func(a slog.Attr) bool {
+		kv = appendAttr(kv, h.prefix, a)
+		return true
+	})
+
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h.l.output(&record{
+		time:     t,
+		level:    levelFromSlog(r.Level),
+		msg:      r.Message,
+		callInfo: callInfoFromPC(r.PC),
+		fields:   fieldsFromKV(kv),
+	})
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var kv []interface{}
+	for _, a := range attrs {
+		kv = appendAttr(kv, h.prefix, a)
+	}
+	return &SlogHandler{l: h.l.With(kv...), prefix: h.prefix}
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{l: h.l, prefix: h.prefix + name + "."}
+}
+
+// appendAttr flattens a into key/value pairs, following the slog rules of
+// ignoring empty attrs and inlining groups without a key.
+func appendAttr(kv []interface{}, prefix string, a slog.Attr) []interface{} {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return kv
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			kv = appendAttr(kv, prefix, ga)
+		}
+		return kv
+	}
+	return append(kv, prefix+a.Key, a.Value.Any())
+}
+
+// levelFromSlog returns the most severe level at or below lvl, or the least
+// severe level when there is none.
+func levelFromSlog(lvl slog.Level) level {
+	sorted := getLevelTable().sorted
+	for i := len(sorted) - 1; i > 0; i-- {
+		if levelToSlog(sorted[i]) <= lvl {
+			return sorted[i]
+		}
+	}
+	return sorted[0]
+}
+
+// levelToSlog maps severities linearly so that DEBUG to ERROR match their
+// slog levels and FATAL is slog.LevelError+4. Unknown levels are FATAL.
+func levelToSlog(lvl level) slog.Level {
+	severity, ok := getLevelTable().severities[lvl]
+	if !ok {
+		return slog.LevelError + 4
+	}
+	return slog.Level((severity - INFO.severity()) * 2 / 5)
+}
+
+func callInfoFromPC(pc uintptr) callInfo {
+	if pc == 0 {
+		return callInfo{file: sourceErr, line: -1}
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return callInfo{file: frame.File, line: frame.Line, pc: frame.PC}
+}
+
+// slogWriter passes the records of a Logger to a slog.Handler.
+type slogWriter struct {
+	h slog.Handler
+}
+
+// NewSlogWriter returns a writer for SetWriter that hands records to h
+// instead of encoding them, so a Logger can feed an existing slog setup. The
+// title and fields of the Logger become attrs. Lines written to it directly
+// are passed on as INFO messages.
+func NewSlogWriter(h slog.Handler) io.Writer {
+	return &slogWriter{h: h}
+}
+
+func (w *slogWriter) Write(p []byte) (n int, err error) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, strings.TrimSuffix(string(p), "\n"), 0)
+	if !w.h.Enabled(context.Background(), r.Level) {
+		return len(p), nil
+	}
+	return len(p), w.h.Handle(context.Background(), r)
+}
+
+func (w *slogWriter) writeRecord(l *Logger, r *record) error {
+	lvl := levelToSlog(r.level)
+	if !w.h.Enabled(context.Background(), lvl) {
+		return nil
+	}
+
+	sr := slog.NewRecord(r.time, lvl, r.msg, r.callInfo.pc)
+	if l.title != "" {
+		sr.AddAttrs(slog.String("title", l.title))
+	}
+	for _, f := range r.fields {
+		sr.AddAttrs(slog.Any(f.key, f.value))
+	}
+	return w.h.Handle(context.Background(), sr)
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"time"
+)
+
+// stdWriter receives the lines of a standard library *log.Logger set up
+// with log.Llongfile and no prefix, and logs each of them as one record.
+// The caller is taken from the file:line written by the standard logger.
+type stdWriter struct {
+	l     *Logger
+	level level
+}
+
+// StdLogger returns a *log.Logger that writes every line through l at lvl,
+// attributed to the code calling the standard logger. Its flags and prefix
+// must not be changed.
+func (l *Logger) StdLogger(lvl level) *log.Logger {
+	return log.New(&stdWriter{l: l, level: lvl}, "", log.Llongfile)
+}
+
+// RedirectStdLog makes the standard library default logger write through l
+// at lvl. The returned function restores its previous output, flags and
+// prefix.
+func RedirectStdLog(l *Logger, lvl level) (restore func()) {
+	out, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	log.SetOutput(&stdWriter{l: l, level: lvl})
+	log.SetFlags(log.Llongfile)
+	log.SetPrefix("")
+	return func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	}
+}
+
+func (w *stdWriter) Write(p []byte) (n int, err error) {
+	if !w.l.enabled(w.level) {
+		return len(p), nil
+	}
+	ci, msg := splitStdLine(p)
+	w.l.output(&record{
+		time:     time.Now(),
+		level:    w.level,
+		msg:      string(bytes.TrimSuffix(msg, []byte("\n"))),
+		callInfo: ci,
+	})
+	return len(p), nil
+}
+
+// splitStdLine splits a line written with log.Llongfile into the caller and
+// the message. Paths may contain colons, so the first ": " preceded by
+// ":<line>" ends the location.
+func splitStdLine(p []byte) (callInfo, []byte) {
+	for start := 0; ; {
+		i := bytes.Index(p[start:], []byte(": "))
+		if i < 0 {
+			return callInfo{file: sourceErr, line: -1}, p
+		}
+		loc := p[:start+i]
+		if j := bytes.LastIndexByte(loc, ':'); j > 0 {
+			if line, err := strconv.Atoi(string(loc[j+1:])); err == nil {
+				return callInfo{file: string(loc[:j]), line: line}, p[start+i+2:]
+			}
+		}
+		start += i + 2
+	}
+}
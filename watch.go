@@ -0,0 +1,226 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// configFlags are the flags controlled by Config.
+const configFlags = Date | Time | Labels | Caller | ShortCaller | Dedup
+
+// ConfigChange describes what a reload of a config file did to one logger.
+// Changed lists the updated settings: "level", "separator", "flags",
+// "format", "writer", "sinks" and "sampling".
+type ConfigChange struct {
+	Logger  string
+	Added   bool
+	Changed []string
+}
+
+/*
+WatchConfigFile loads the config file at path like LoadConfigFile and then
+polls it every interval. When its content changes, loggers that are already
+registered are updated in place and new ones are registered. Settings changed
+at runtime, i.e. with SetLevelForLogger, are only overwritten when the file
+changes them. Every reload that changed something or failed is reported to
+onChange, which may be nil. The interval must be positive. Call stop to end
+watching.
+
+In example:
+
+	stop, err := logging.WatchConfigFile("logging.yaml", 5*time.Second,
+		func(changes []logging.ConfigChange, err error) {
+			...
+		})
+*/
+func WatchConfigFile(path string, interval time.Duration, onChange func([]ConfigChange, error)) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval %s invalid", interval)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = reloadConfigFile(path)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var lastErr string
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			current, err := os.ReadFile(path)
+			if err != nil {
+				if err.Error() != lastErr && onChange != nil {
+					onChange(nil, err)
+				}
+				lastErr = err.Error()
+				continue
+			}
+			lastErr = ""
+			if bytes.Equal(current, data) {
+				continue
+			}
+			data = current
+
+			changes, err := reloadConfigFile(path)
+			if (len(changes) > 0 || err != nil) && onChange != nil {
+				onChange(changes, err)
+			}
+		}
+	}()
+
+	return func() { close(done) }, err
+}
+
+// reloadConfigFile applies the config file at path to the registry.
+func reloadConfigFile(path string) ([]ConfigChange, error) {
+	configs, err := readConfigFile(path)
+	errs := configErrors(err)
+
+	var changes []ConfigChange
+	for _, cfg := range configs {
+		change := ConfigChange{Logger: cfg.Title}
+		if l, err := GetLogger(cfg.Title); err == nil {
+			change.Changed, err = l.applyConfig(cfg)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		} else if _, err := AddLoggerFromConfig(cfg); err == nil {
+			change.Added = true
+		} else {
+			errs = append(errs, err)
+		}
+
+		if change.Added || len(change.Changed) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes, errs.errorOrNil()
+}
+
+// applyConfig updates l in place to match cfg and returns the names of the
+// settings that changed. Nothing is changed when cfg is invalid.
+func (l *Logger) applyConfig(cfg Config) (changed []string, err error) {
+	if errs := cfg.validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config for logger %s: %w", cfg.Title, errs)
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.currentConfig(cfg)
+	var (
+		w     io.Writer
+		owned bool
+		sinks []*sink
+	)
+	if !old.sameWriter(cfg) && cfg.hasWriter() {
+		if w, owned, err = cfg.openWriter(cfg); err != nil {
+			return nil, fmt.Errorf("invalid config for logger %s: %w", cfg.Title, err)
+		}
+	}
+	if !old.sameSinks(cfg) {
+		var errs multiError
+		if sinks, errs = openSinks(cfg); len(errs) > 0 {
+			closeSinks(sinks)
+			if c, ok := w.(io.Closer); ok && owned {
+				_ = c.Close()
+			}
+			return nil, fmt.Errorf("invalid config for logger %s: %w", cfg.Title, errs)
+		}
+	}
+
+	if !old.sameWriter(cfg) {
+		if c, ok := l.w.(io.Closer); ok && l.ownsW {
+			_ = c.Close()
+		}
+		l.w = w
+		l.ownsW = owned
+		l.writers = nil
+		changed = append(changed, "writer")
+	}
+	if !old.sameSinks(cfg) {
+		closeSinks(l.sinks)
+		l.sinks = sinks
+		changed = append(changed, "sinks")
+	}
+	if old.level() != cfg.level() {
+		l.level.store(cfg.level())
+		l.originalLevel = cfg.level()
+		changed = append(changed, "level")
+	}
+	if old.separator() != cfg.separator() {
+		l.separator = cfg.separator()
+		changed = append(changed, "separator")
+	}
+	if old.flags() != cfg.flags() {
+		l.flag = l.flag&^configFlags | cfg.flags()
+		changed = append(changed, "flags")
+	}
+	if old.format() != cfg.format() {
+		l.enc, _ = encoderFromFormat(cfg.Format)
+		changed = append(changed, "format")
+	}
+	if old.Sampling != cfg.Sampling || old.RateLimit != cfg.RateLimit {
+		l.limiter = newLimiter(cfg.Sampling, cfg.RateLimit)
+		changed = append(changed, "sampling")
+	}
+
+	l.cfg = &cfg
+	return changed, nil
+}
+
+// currentConfig returns the config l was built from. For loggers built in
+// code it is derived from their settings; their writer is only considered
+// different from next when next sets a direction. l.mu must be held.
+func (l *Logger) currentConfig(next Config) Config {
+	if l.cfg != nil {
+		return *l.cfg
+	}
+
+	cfg := Config{
+		Title:             l.title,
+		Separator:         l.separator,
+		Level:             l.originalLevel,
+		EnableDate:        l.flag&Date != 0,
+		EnableTime:        l.flag&Time != 0,
+		EnableLabels:      l.flag&Labels != 0,
+		EnableCaller:      l.flag&Caller != 0,
+		EnableShortCaller: l.flag&ShortCaller != 0,
+		EnableDedup:       l.flag&Dedup != 0,
+	}
+	if l.limiter != nil {
+		cfg.Sampling = l.limiter.sampling
+		cfg.RateLimit = l.limiter.rate
+	}
+	for f, enc := range encoders {
+		if enc == l.getEncoder() {
+			cfg.Format = f
+		}
+	}
+	if next.Direction == "" {
+		cfg.Sinks = next.Sinks
+		cfg.Async = next.Async
+		cfg.AsyncQueueSize = next.AsyncQueueSize
+		cfg.AsyncOverflow = next.AsyncOverflow
+		cfg.Direction = next.Direction
+		cfg.MaxSizeMB = next.MaxSizeMB
+		cfg.MaxBackups = next.MaxBackups
+		cfg.MaxAgeDays = next.MaxAgeDays
+		cfg.Compress = next.Compress
+	}
+	return cfg
+}